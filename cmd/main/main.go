@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/badiwidya/yaurl/internal/app"
 	"github.com/badiwidya/yaurl/internal/config"
@@ -14,6 +15,21 @@ func main() {
 	}
 	cfg := config.New()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			if err := app.RunImport(cfg, os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Import failed: %v\n", err)
+			}
+			return
+		case "admin":
+			if err := app.RunAdmin(cfg, os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Admin update failed: %v\n", err)
+			}
+			return
+		}
+	}
+
 	server, err := app.NewServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize server: %v\n", err)
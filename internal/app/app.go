@@ -8,12 +8,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/badiwidya/yaurl/internal/auth"
 	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/pkg/geoip"
 	"github.com/badiwidya/yaurl/internal/pkg/middlewares"
+	"github.com/badiwidya/yaurl/internal/pkg/ratelimit"
+	"github.com/badiwidya/yaurl/internal/pkg/reputation"
 	"github.com/badiwidya/yaurl/internal/shortener"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -23,6 +28,12 @@ type Server struct {
 	db         *sql.DB
 	logger     *slog.Logger
 	cfg        *config.Config
+	visitors   *shortener.Visitors
+	clicks     *shortener.ClickRecorder
+	shortener  shortener.Service
+	reputation *reputation.Store
+	unlocks    *ratelimit.Limiter
+	jobs       sync.WaitGroup
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -37,13 +48,64 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	}
 	logger.Info("Database connected successfully")
 
+	var geo *geoip.DB
+	if cfg.GEOIP_DB_PATH != "" {
+		geo, err = geoip.Open(cfg.GEOIP_DB_PATH)
+		if err != nil {
+			logger.Error("Failed to open GeoIP database", "path", cfg.GEOIP_DB_PATH, "error", err.Error())
+			return nil, err
+		}
+		logger.Info("GeoIP database loaded", "type", geo.DatabaseType)
+	}
+	visitors := shortener.NewVisitors(cfg, geo)
+
+	policy, err := shortener.NewPolicy(cfg, logger.With("op", "policy"))
+	if err != nil {
+		logger.Error("Failed to load destination policy", "error", err.Error())
+		return nil, err
+	}
+
+	store := newReputationStore(cfg, logger.With("op", "reputation"))
+
+	shortenerService, err := shortener.NewService(cfg, logger.With("op", "shortener"), db, policy, store)
+	if err != nil {
+		logger.Error("Failed to set up the shortener", "error", err.Error())
+		return nil, err
+	}
+
 	return &Server{
-		db:     db,
-		logger: logger,
-		cfg:    cfg,
+		db:         db,
+		logger:     logger,
+		cfg:        cfg,
+		visitors:   visitors,
+		clicks:     shortener.NewClickRecorder(cfg, logger.With("op", "clicks"), db, visitors),
+		shortener:  shortenerService,
+		reputation: store,
+		unlocks:    ratelimit.New(cfg.UNLOCK_MAX_ATTEMPTS, cfg.UNLOCK_WINDOW),
 	}, nil
 }
 
+// newReputationStore loads the configured reputation lists. Sources that
+// can't be loaded are logged and retried on the next refresh, links are
+// still created meanwhile.
+func newReputationStore(cfg *config.Config, logger *slog.Logger) *reputation.Store {
+	var sources []string
+	for source := range strings.SplitSeq(cfg.REPUTATION_SOURCES, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+
+	store := reputation.NewStore(sources, cfg.REPUTATION_CACHE_DIR, logger)
+	if store.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		store.Refresh(ctx)
+	}
+
+	return store
+}
+
 func (s *Server) Run() error {
 	router := s.setupRouter()
 	address := s.cfg.APP_HOST + ":" + s.cfg.APP_PORT
@@ -53,6 +115,10 @@ func (s *Server) Run() error {
 		Handler: router,
 	}
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	s.startJobs(jobsCtx)
+
 	serverErrors := make(chan error, 1)
 
 	go func() {
@@ -80,6 +146,15 @@ func (s *Server) Run() error {
 		s.logger.Error("Server shutdown failed", "error", err.Error())
 	}
 
+	s.logger.Info("Stopping background jobs...")
+	stopJobs()
+	s.jobs.Wait()
+
+	s.logger.Info("Flushing buffered clicks...")
+	if err := s.clicks.Close(ctx); err != nil {
+		s.logger.Error("Failed to flush clicks", "error", err.Error())
+	}
+
 	s.logger.Info("Closing database connection...")
 	if err := s.db.Close(); err != nil {
 		s.logger.Error("Failed to close database", "error", err.Error())
@@ -92,17 +167,45 @@ func (s *Server) Run() error {
 func (s *Server) setupRouter() http.Handler {
 	mux := http.NewServeMux()
 
-	shortenerService := shortener.NewService(s.cfg, s.logger.With("op", "shortener"), s.db)
-	shortenerHandler := shortener.NewHandler(shortenerService)
+	shortenerHandler := shortener.NewHandler(s.shortener, s.logger.With("op", "shortener"), s.clicks, s.visitors, s.unlocks)
 	authService := auth.NewService(s.db, s.logger.With("op", "auth"))
 	authHandler := auth.NewHandler(authService)
 
 	authMiddleware := middlewares.NewAuthRequired(s.db)
+	adminMiddleware := func(next http.Handler) http.Handler {
+		return authMiddleware(middlewares.NewAdminRequired(s.db)(next))
+	}
 
 	authRoutes := auth.RegisterRoutes(authHandler, authMiddleware)
 
-	mux.Handle("/api/auth/", http.StripPrefix("/api/auth", authRoutes))
+	// Every auth route is a POST, the method keeps this mount from
+	// overlapping with the GET /{code}/{rest...} redirects.
+	mux.Handle("POST /api/auth/", http.StripPrefix("/api/auth", authRoutes))
 	mux.Handle("POST /api/url", authMiddleware(http.HandlerFunc(shortenerHandler.ShortenURL)))
+	mux.Handle("GET /api/urls", authMiddleware(http.HandlerFunc(shortenerHandler.ListUrls)))
+	mux.Handle("GET /api/urls/export", authMiddleware(http.HandlerFunc(shortenerHandler.ExportUrls)))
+	mux.Handle("POST /api/urls/import", authMiddleware(http.HandlerFunc(shortenerHandler.ImportUrls)))
+	mux.Handle("POST /api/urls/bulk", authMiddleware(http.HandlerFunc(shortenerHandler.BulkShortenURLs)))
+	mux.Handle("GET /api/events", authMiddleware(http.HandlerFunc(shortenerHandler.ListUrlEvents)))
+	mux.Handle("PATCH /api/url/{code}", authMiddleware(http.HandlerFunc(shortenerHandler.UpdateUrl)))
+	mux.Handle("DELETE /api/url/{code}", authMiddleware(http.HandlerFunc(shortenerHandler.DeleteUrl)))
+	mux.Handle("POST /api/url/{code}/disable", authMiddleware(http.HandlerFunc(shortenerHandler.DisableUrl)))
+	mux.Handle("POST /api/url/{code}/enable", authMiddleware(http.HandlerFunc(shortenerHandler.EnableUrl)))
+	mux.Handle("POST /api/url/{code}/restore", authMiddleware(http.HandlerFunc(shortenerHandler.RestoreUrl)))
+	mux.Handle("GET /api/url/{code}/stats", authMiddleware(http.HandlerFunc(shortenerHandler.GetUrlStats)))
+	mux.Handle("GET /api/url/{code}/history", authMiddleware(http.HandlerFunc(shortenerHandler.GetUrlHistory)))
+	mux.Handle("POST /api/url/{code}/history/{id}/rollback", authMiddleware(http.HandlerFunc(shortenerHandler.RollbackUrl)))
+	mux.Handle("GET /api/url/{code}/health", authMiddleware(http.HandlerFunc(shortenerHandler.GetUrlHealth)))
+	mux.Handle("POST /api/url/{code}/metadata/refresh", authMiddleware(http.HandlerFunc(shortenerHandler.RefreshUrlMetadata)))
+	mux.Handle("GET /api/url/{code}/schedule", authMiddleware(http.HandlerFunc(shortenerHandler.GetUrlSchedule)))
+	mux.Handle("PUT /api/url/{code}/schedule", authMiddleware(http.HandlerFunc(shortenerHandler.UpdateUrlSchedule)))
+
+	mux.Handle("GET /api/admin/preview-domains", adminMiddleware(http.HandlerFunc(shortenerHandler.ListPreviewDomains)))
+	mux.Handle("POST /api/admin/preview-domains", adminMiddleware(http.HandlerFunc(shortenerHandler.AddPreviewDomain)))
+	mux.Handle("DELETE /api/admin/preview-domains/{domain}", adminMiddleware(http.HandlerFunc(shortenerHandler.RemovePreviewDomain)))
+	mux.Handle("POST /api/admin/destination-policy/reload", adminMiddleware(http.HandlerFunc(shortenerHandler.ReloadDestinationPolicy)))
+	mux.Handle("GET /api/admin/flagged-links", adminMiddleware(http.HandlerFunc(shortenerHandler.ListFlaggedUrls)))
+	mux.Handle("POST /api/admin/flagged-links/{code}/clear", adminMiddleware(http.HandlerFunc(shortenerHandler.ClearUrlFlag)))
 
 	mux.HandleFunc("GET /web/login", func(w http.ResponseWriter, r *http.Request) {
 		s.serveTemplate(w, "login.gohtml", nil)
@@ -114,6 +217,12 @@ func (s *Server) setupRouter() http.Handler {
 
 	mux.HandleFunc("GET /web", s.handleHomepage())
 	mux.HandleFunc("GET /{code}", shortenerHandler.RedirectUrl)
+	mux.HandleFunc("GET /{code}/{rest...}", shortenerHandler.RedirectUrl)
+	mux.HandleFunc("POST /{code}", shortenerHandler.UnlockUrl)
+	mux.HandleFunc("POST /{code}/{rest...}", shortenerHandler.UnlockUrl)
+	// More specific than the passthrough route, a forwarded path of exactly
+	// "qr" is served the QR code instead.
+	mux.HandleFunc("GET /{code}/qr", shortenerHandler.GetQRCode)
 
 	return mux
 }
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/shortener"
+)
+
+// RunImport implements the "import" subcommand, which loads another
+// shortener's export into the urls table on behalf of an existing user.
+func RunImport(cfg *config.Config, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "export format: bitly, yourls_sql, yourls_json or kutt")
+	username := flags.String("user", "", "username that will own the imported links")
+	file := flags.String("file", "", "path to the export file, - reads stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *format == "" || *username == "" || *file == "" {
+		flags.Usage()
+		return errors.New("-format, -user and -file are required")
+	}
+
+	input := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	links, err := shortener.ParseImport(*format, input)
+	if err != nil {
+		return fmt.Errorf("parsing %s export: %w", *format, err)
+	}
+
+	logger := createNewLogger(cfg.GetLogLevel())
+
+	db, err := initDatabase(cfg.DB_STRING)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var userId int
+	row := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", strings.ToLower(*username))
+	if err := row.Scan(&userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %q does not exist", *username)
+		}
+		return err
+	}
+
+	policy, err := shortener.NewPolicy(cfg, logger.With("op", "policy"))
+	if err != nil {
+		return fmt.Errorf("loading destination policy: %w", err)
+	}
+
+	store := newReputationStore(cfg, logger.With("op", "reputation"))
+	service, err := shortener.NewService(cfg, logger.With("op", "import"), db, policy, store)
+	if err != nil {
+		return fmt.Errorf("setting up the shortener: %w", err)
+	}
+
+	report, err := service.ImportUrls(ctx, userId, links)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range report.Results {
+		if result.Status != shortener.ImportStatusImported {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", result.Status, result.Code, result.Url, result.Reason)
+		}
+	}
+	fmt.Fprintf(stdout, "imported: %d, conflicts: %d, invalid: %d\n", report.Imported, report.Conflicts, report.Invalid)
+
+	return nil
+}
+
+// RunAdmin implements the "admin" subcommand, which grants or revokes the
+// administrator role of an existing user.
+func RunAdmin(cfg *config.Config, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	username := flags.String("user", "", "username to update")
+	revoke := flags.Bool("revoke", false, "revoke the administrator role instead of granting it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		flags.Usage()
+		return errors.New("-user is required")
+	}
+
+	db, err := initDatabase(cfg.DB_STRING)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, "UPDATE users SET is_admin = $2 WHERE username = $1", strings.ToLower(*username), !*revoke)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("user %q does not exist", *username)
+	}
+
+	if *revoke {
+		fmt.Fprintf(stdout, "%s is no longer an administrator\n", *username)
+	} else {
+		fmt.Fprintf(stdout, "%s is now an administrator\n", *username)
+	}
+
+	return nil
+}
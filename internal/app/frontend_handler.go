@@ -1,9 +1,9 @@
 package app
 
 import (
-	"html/template"
 	"net/http"
-	"path/filepath"
+
+	"github.com/badiwidya/yaurl/internal/pkg/utils"
 )
 
 func (s *Server) handleHomepage() http.HandlerFunc {
@@ -35,18 +35,8 @@ func (s *Server) handleHomepage() http.HandlerFunc {
 }
 
 func (s *Server) serveTemplate(w http.ResponseWriter, pageName string, data any) {
-	pagePath := filepath.Join("templates", pageName)
-	layoutPath := filepath.Join("templates", "layout.gohtml")
-
-	tmpl, err := template.ParseFiles(layoutPath, pagePath)
-	if err != nil {
-		s.logger.Error("Failed to parse html templates", "error", err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	if err := tmpl.Execute(w, data); err != nil {
-		s.logger.Error("Failed to execute template", "error", err.Error())
+	if err := utils.HTMLResponse(w, http.StatusOK, pageName, data); err != nil {
+		s.logger.Error("Failed to render html template", "page", pageName, "error", err.Error())
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
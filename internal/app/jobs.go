@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (s *Server) startJobs(ctx context.Context) {
+	s.runPeriodic(ctx, "purge_trash", s.cfg.TRASH_PURGE_INTERVAL, func(ctx context.Context) error {
+		_, err := s.shortener.PurgeTrash(ctx)
+		return err
+	})
+
+	s.runPeriodic(ctx, "check_health", s.cfg.HEALTH_CHECK_INTERVAL, func(ctx context.Context) error {
+		_, err := s.shortener.CheckLinkHealth(ctx)
+		return err
+	})
+
+	s.runPeriodic(ctx, "fill_metadata", s.cfg.METADATA_BACKFILL_INTERVAL, func(ctx context.Context) error {
+		_, err := s.shortener.FillMissingMetadata(ctx)
+		return err
+	})
+
+	s.runPeriodic(ctx, "sweep_unlock_limits", s.cfg.UNLOCK_WINDOW, func(context.Context) error {
+		s.unlocks.Sweep()
+		return nil
+	})
+
+	if s.reputation.Enabled() {
+		s.runPeriodic(ctx, "refresh_reputation", s.cfg.REPUTATION_REFRESH_INTERVAL, s.reputation.Refresh)
+		s.runPeriodic(ctx, "recheck_reputation", s.cfg.REPUTATION_RECHECK_INTERVAL, func(ctx context.Context) error {
+			_, err := s.shortener.RecheckReputation(ctx)
+			return err
+		})
+	}
+
+	s.reloadOnHangup(ctx)
+}
+
+// reloadOnHangup reloads the destination policy whenever the process
+// receives SIGHUP, failures are logged by the service.
+func (s *Server) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		defer signal.Stop(hangup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				s.shortener.ReloadDestinationPolicy()
+			}
+		}
+	}()
+}
+
+// runPeriodic runs job every interval until ctx is cancelled. Each run gets
+// its own timeout so a stuck query cannot stall the next one.
+func (s *Server) runPeriodic(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	if interval <= 0 {
+		s.logger.Info("Background job disabled", "job", name)
+		return
+	}
+
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runCtx, cancel := context.WithTimeout(ctx, interval)
+				if err := job(runCtx); err != nil {
+					s.logger.Error("Background job failed", "job", name, "error", err.Error())
+				}
+				cancel()
+			}
+		}
+	}()
+}
@@ -139,3 +139,15 @@ func generateSessionID() (string, error) {
 
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// HashPassword hashes password with argon2id using the default parameters,
+// for packages that store secrets of their own.
+func HashPassword(password string) (string, error) {
+	return hashPassword(password, defaultParams)
+}
+
+// ComparePassword reports whether password matches an encoded hash
+// produced by HashPassword.
+func ComparePassword(password, encodedHash string) (bool, error) {
+	return comparePassAndHash(password, encodedHash)
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log/slog"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -11,6 +13,55 @@ type Config struct {
 	APP_BASE_URL string
 	DB_STRING    string
 	LOG_LEVEL    string
+
+	SHORT_CODE_GENERATOR string
+	SHORT_CODE_LENGTH    int
+	SHORT_CODE_ALPHABET  string
+
+	CLICK_BUFFER_SIZE    int
+	CLICK_BATCH_SIZE     int
+	CLICK_FLUSH_INTERVAL time.Duration
+	CLICK_IP_SALT        string
+	TRUST_PROXY_HEADERS  bool
+	GEO_COUNTRY_HEADER   string
+	GEOIP_DB_PATH        string
+
+	TRASH_RETENTION      time.Duration
+	TRASH_PURGE_INTERVAL time.Duration
+
+	BULK_MODE     string
+	BULK_MAX_ROWS int
+
+	UNLOCK_MAX_ATTEMPTS int
+	UNLOCK_WINDOW       time.Duration
+
+	REDIRECT_STATUS        int
+	REDIRECT_CACHE_MAX_AGE time.Duration
+
+	DESTINATION_SCHEMES      string
+	DESTINATION_DOMAINS_FILE string
+
+	REPUTATION_SOURCES          string
+	REPUTATION_CACHE_DIR        string
+	REPUTATION_REFRESH_INTERVAL time.Duration
+	REPUTATION_RECHECK_INTERVAL time.Duration
+
+	HEALTH_CHECK_INTERVAL    time.Duration
+	HEALTH_CHECK_BATCH_SIZE  int
+	HEALTH_CHECK_CONCURRENCY int
+	HEALTH_CHECK_TIMEOUT     time.Duration
+	HEALTH_HOST_DELAY        time.Duration
+	HEALTH_FAILURE_THRESHOLD int
+	HEALTH_HISTORY_RETENTION time.Duration
+
+	METADATA_FETCH_ON_CREATE   bool
+	METADATA_TIMEOUT           time.Duration
+	METADATA_MAX_BYTES         int64
+	METADATA_BACKFILL_INTERVAL time.Duration
+	METADATA_BACKFILL_BATCH    int
+
+	UNFURL_ENABLED     bool
+	UNFURL_USER_AGENTS string
 }
 
 func New() *Config {
@@ -20,9 +71,85 @@ func New() *Config {
 		APP_BASE_URL: os.Getenv("APP_BASE_URL"),
 		DB_STRING:    os.Getenv("GOOSE_DBSTRING"),
 		LOG_LEVEL:    os.Getenv("LOG_LEVEL"),
+
+		SHORT_CODE_GENERATOR: os.Getenv("SHORT_CODE_GENERATOR"),
+		SHORT_CODE_LENGTH:    getEnvInt("SHORT_CODE_LENGTH", 7),
+		SHORT_CODE_ALPHABET:  os.Getenv("SHORT_CODE_ALPHABET"),
+
+		CLICK_BUFFER_SIZE:    getEnvInt("CLICK_BUFFER_SIZE", 1024),
+		CLICK_BATCH_SIZE:     getEnvInt("CLICK_BATCH_SIZE", 100),
+		CLICK_FLUSH_INTERVAL: getEnvDuration("CLICK_FLUSH_INTERVAL", 2*time.Second),
+		CLICK_IP_SALT:        os.Getenv("CLICK_IP_SALT"),
+		TRUST_PROXY_HEADERS:  getEnvBool("TRUST_PROXY_HEADERS", false),
+		GEO_COUNTRY_HEADER:   os.Getenv("GEO_COUNTRY_HEADER"),
+		GEOIP_DB_PATH:        os.Getenv("GEOIP_DB_PATH"),
+
+		TRASH_RETENTION:      getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		TRASH_PURGE_INTERVAL: getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour),
+
+		BULK_MODE:     os.Getenv("BULK_MODE"),
+		BULK_MAX_ROWS: getEnvInt("BULK_MAX_ROWS", 1000),
+
+		UNLOCK_MAX_ATTEMPTS: getEnvInt("UNLOCK_MAX_ATTEMPTS", 5),
+		UNLOCK_WINDOW:       getEnvDuration("UNLOCK_WINDOW", 15*time.Minute),
+
+		REDIRECT_STATUS:        getEnvInt("REDIRECT_STATUS", 302),
+		REDIRECT_CACHE_MAX_AGE: getEnvDuration("REDIRECT_CACHE_MAX_AGE", 24*time.Hour),
+
+		DESTINATION_SCHEMES:      os.Getenv("DESTINATION_SCHEMES"),
+		DESTINATION_DOMAINS_FILE: os.Getenv("DESTINATION_DOMAINS_FILE"),
+
+		REPUTATION_SOURCES:          os.Getenv("REPUTATION_SOURCES"),
+		REPUTATION_CACHE_DIR:        os.Getenv("REPUTATION_CACHE_DIR"),
+		REPUTATION_REFRESH_INTERVAL: getEnvDuration("REPUTATION_REFRESH_INTERVAL", time.Hour),
+		REPUTATION_RECHECK_INTERVAL: getEnvDuration("REPUTATION_RECHECK_INTERVAL", 6*time.Hour),
+
+		HEALTH_CHECK_INTERVAL:    getEnvDuration("HEALTH_CHECK_INTERVAL", time.Hour),
+		HEALTH_CHECK_BATCH_SIZE:  getEnvInt("HEALTH_CHECK_BATCH_SIZE", 500),
+		HEALTH_CHECK_CONCURRENCY: getEnvInt("HEALTH_CHECK_CONCURRENCY", 8),
+		HEALTH_CHECK_TIMEOUT:     getEnvDuration("HEALTH_CHECK_TIMEOUT", 10*time.Second),
+		HEALTH_HOST_DELAY:        getEnvDuration("HEALTH_HOST_DELAY", time.Second),
+		HEALTH_FAILURE_THRESHOLD: getEnvInt("HEALTH_FAILURE_THRESHOLD", 3),
+		HEALTH_HISTORY_RETENTION: getEnvDuration("HEALTH_HISTORY_RETENTION", 30*24*time.Hour),
+
+		METADATA_FETCH_ON_CREATE:   getEnvBool("METADATA_FETCH_ON_CREATE", true),
+		METADATA_TIMEOUT:           getEnvDuration("METADATA_TIMEOUT", 5*time.Second),
+		METADATA_MAX_BYTES:         int64(getEnvInt("METADATA_MAX_BYTES", 512<<10)),
+		METADATA_BACKFILL_INTERVAL: getEnvDuration("METADATA_BACKFILL_INTERVAL", 10*time.Minute),
+		METADATA_BACKFILL_BATCH:    getEnvInt("METADATA_BACKFILL_BATCH", 100),
+
+		UNFURL_ENABLED:     getEnvBool("UNFURL_ENABLED", true),
+		UNFURL_USER_AGENTS: os.Getenv("UNFURL_USER_AGENTS"),
 	}
 }
 
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
 func (c *Config) GetLogLevel() slog.Level {
 	switch c.LOG_LEVEL {
 	case "debug":
@@ -0,0 +1,383 @@
+// Package geoip looks up the country of an IP address in a MaxMind DB
+// file, such as GeoLite2-Country.mmdb, without any third-party reader.
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+var ErrInvalidDatabase = errors.New("geoip: invalid MaxMind DB file")
+
+// metadataMarker precedes the metadata map at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the size of the zeroed gap between the search
+// tree and the data section.
+const dataSectionSeparator = 16
+
+// DB is an mmdb file loaded into memory. It is safe for concurrent use.
+type DB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+
+	DatabaseType string
+}
+
+func Open(path string) (*DB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(buf)
+}
+
+func New(buf []byte) (*DB, error) {
+	markerAt := bytes.LastIndex(buf, metadataMarker)
+	if markerAt < 0 {
+		return nil, ErrInvalidDatabase
+	}
+
+	metadataDecoder := decoder{buf: buf[markerAt+len(metadataMarker):]}
+	raw, _, err := metadataDecoder.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: reading metadata: %w", err)
+	}
+	metadata, ok := raw.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	db := &DB{buf: buf}
+	db.nodeCount = uint(asUint(metadata["node_count"]))
+	db.recordSize = uint(asUint(metadata["record_size"]))
+	db.ipVersion = uint(asUint(metadata["ip_version"]))
+	db.DatabaseType, _ = metadata["database_type"].(string)
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("geoip: unsupported record size %d", db.recordSize)
+	}
+
+	treeSize := db.recordSize * 2 / 8 * db.nodeCount
+	if treeSize+dataSectionSeparator > uint(markerAt) {
+		return nil, ErrInvalidDatabase
+	}
+	db.data = buf[treeSize+dataSectionSeparator : markerAt]
+
+	// IPv4 addresses live under ::/96 in an IPv6 tree.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// Lookup returns the record stored for addr, or nil when there is none.
+func (db *DB) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+
+	node := uint(0)
+	bits := addr.BitLen()
+	if addr.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if addr.Is6() && db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	ip := addr.AsSlice()
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-i&7)) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, ErrInvalidDatabase
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, ErrInvalidDatabase
+	}
+
+	d := decoder{buf: db.data}
+	value, _, err := d.decode(offset)
+
+	return value, err
+}
+
+// Country returns the ISO 3166 code of the country addr is located in,
+// falling back to the country it is registered in. It is empty when the
+// database doesn't know.
+func (db *DB) Country(addr netip.Addr) string {
+	record, err := db.Lookup(addr)
+	if err != nil || record == nil {
+		return ""
+	}
+
+	for _, key := range []string{"country", "registered_country"} {
+		if code := lookupString(record, key, "iso_code"); code != "" {
+			return code
+		}
+	}
+
+	return ""
+}
+
+func (db *DB) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		b := db.buf[node*8+bit*4:]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3])
+	}
+}
+
+func lookupString(value any, path ...string) string {
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[key]
+	}
+
+	s, _ := value.(string)
+
+	return s
+}
+
+func asUint(value any) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int32:
+		if v >= 0 {
+			return uint64(v)
+		}
+	}
+
+	return 0
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting so a corrupt file can't recurse forever.
+const maxDepth = 64
+
+// decoder reads the MaxMind DB data section format.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+// decode returns the value at offset and the offset right after it.
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, 0, ErrInvalidDatabase
+	}
+
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(uint64(beUint(b))), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float32frombits(uint32(beUint(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return beUint(b), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return int32(uint32(beUint(b))), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("geoip: unsupported data type %d", typ)
+}
+
+// control parses a control byte and the size bytes that follow it.
+func (d *decoder) control(offset uint) (typ int, size uint, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, ErrInvalidDatabase
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	typ = int(ctrl >> 5)
+	if typ == typePointer {
+		// Pointers keep their own size encoding in the low bits.
+		return typ, uint(ctrl & 0x1f), offset, nil
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, ErrInvalidDatabase
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, ErrInvalidDatabase
+		}
+		extra := uint(beUint(d.buf[offset : offset+n]))
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	return typ, size, offset, nil
+}
+
+func (d *decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrInvalidDatabase
+	}
+
+	b := d.buf[offset : offset+n]
+	high := bits & 0x7
+
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = high<<8 | uint(beUint(b))
+	case 2:
+		pointer = (high<<16 | uint(beUint(b))) + 2048
+	case 3:
+		pointer = (high<<24 | uint(beUint(b))) + 526336
+	default:
+		pointer = uint(beUint(b))
+	}
+
+	return pointer, offset + n, nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
@@ -0,0 +1,283 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// mmdbWriter builds a small MaxMind DB file. Records point at a node when
+// they are >= 0, are empty at -1 and point at data entry i at -2-i.
+type mmdbWriter struct {
+	ipVersion  int
+	recordSize int
+	nodes      [][2]int
+	data       [][]byte
+}
+
+func newMMDBWriter(ipVersion, recordSize int) *mmdbWriter {
+	return &mmdbWriter{
+		ipVersion:  ipVersion,
+		recordSize: recordSize,
+		nodes:      [][2]int{{-1, -1}},
+	}
+}
+
+// addData appends an encoded data entry and returns its index.
+func (w *mmdbWriter) addData(encoded []byte) int {
+	w.data = append(w.data, encoded)
+	return len(w.data) - 1
+}
+
+// dataOffset returns where data entry starts in the data section.
+func (w *mmdbWriter) dataOffset(entry int) int {
+	offset := 0
+	for _, d := range w.data[:entry] {
+		offset += len(d)
+	}
+	return offset
+}
+
+// insert stores data entry for prefix. IPv4 networks go under ::/96 in an
+// IPv6 tree.
+func (w *mmdbWriter) insert(prefix netip.Prefix, entry int) {
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4() && w.ipVersion == 6 {
+		var mapped [16]byte
+		copy(mapped[12:], addr.AsSlice())
+		addr, bits = netip.AddrFrom16(mapped), bits+96
+	}
+
+	ip := addr.AsSlice()
+	node := 0
+	for i := range bits {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if i == bits-1 {
+			w.nodes[node][bit] = -2 - entry
+			return
+		}
+		next := w.nodes[node][bit]
+		if next < 0 {
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+			next = len(w.nodes) - 1
+			w.nodes[node][bit] = next
+		}
+		node = next
+	}
+}
+
+func (w *mmdbWriter) bytes(metadata map[string][]byte) []byte {
+	nodeCount := len(w.nodes)
+	value := func(record int) uint {
+		switch {
+		case record >= 0:
+			return uint(record)
+		case record == -1:
+			return uint(nodeCount)
+		default:
+			return uint(nodeCount + dataSectionSeparator + w.dataOffset(-2-record))
+		}
+	}
+
+	var buf []byte
+	for _, node := range w.nodes {
+		left, right := value(node[0]), value(node[1])
+		switch w.recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24&0x0f)<<4|byte(right>>24&0x0f),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = append(buf, byte(left>>24), byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>24), byte(right>>16), byte(right>>8), byte(right))
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	for _, d := range w.data {
+		buf = append(buf, d...)
+	}
+
+	buf = append(buf, metadataMarker...)
+	fields := map[string][]byte{
+		"node_count":    encodeUint(6, uint64(nodeCount)),
+		"record_size":   encodeUint(5, uint64(w.recordSize)),
+		"ip_version":    encodeUint(5, uint64(w.ipVersion)),
+		"database_type": encodeString("Test-Country"),
+	}
+	for key, value := range metadata {
+		fields[key] = value
+	}
+
+	return append(buf, encodeMap(fields)...)
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+// encodeUint encodes n as type 5 (uint16), 6 (uint32) or 9 (uint64, an
+// extended type).
+func encodeUint(typ byte, n uint64) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	if typ > 7 {
+		return append([]byte{byte(len(b)), typ - 7}, b...)
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+func encodeMap(fields map[string][]byte) []byte {
+	b := []byte{7<<5 | byte(len(fields))}
+	for key, value := range fields {
+		b = append(b, encodeString(key)...)
+		b = append(b, value...)
+	}
+	return b
+}
+
+func encodePointer(offset int) []byte {
+	return []byte{1<<5 | byte(offset>>8&0x7), byte(offset)}
+}
+
+func countryRecord(key, code string) []byte {
+	return encodeMap(map[string][]byte{
+		key: encodeMap(map[string][]byte{
+			"iso_code":   encodeString(code),
+			"geoname_id": encodeUint(6, 2921044),
+		}),
+	})
+}
+
+func newTestDB(t *testing.T, ipVersion, recordSize int) *DB {
+	t.Helper()
+
+	w := newMMDBWriter(ipVersion, recordSize)
+	de := w.addData(countryRecord("country", "DE"))
+	w.insert(netip.MustParsePrefix("1.2.3.0/24"), de)
+	// A second network shares the first record through a pointer.
+	w.insert(netip.MustParsePrefix("1.2.5.0/24"), w.addData(encodePointer(w.dataOffset(de))))
+	w.insert(netip.MustParsePrefix("81.0.0.0/8"), w.addData(countryRecord("registered_country", "FR")))
+	if ipVersion == 6 {
+		w.insert(netip.MustParsePrefix("2001:db8::/32"), w.addData(countryRecord("country", "NL")))
+	}
+
+	db, err := New(w.bytes(map[string][]byte{"build_epoch": encodeUint(9, 1700000000)}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return db
+}
+
+func TestCountry(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"1.2.3.4", "DE"},
+		{"1.2.3.255", "DE"},
+		{"::ffff:1.2.3.4", "DE"},
+		{"1.2.5.9", "DE"},
+		{"81.10.20.30", "FR"},
+		{"1.2.4.1", ""},
+		{"8.8.8.8", ""},
+		{"2001:db8::1", "NL"},
+		{"2001:db9::1", ""},
+	}
+
+	for _, version := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			db := newTestDB(t, version, recordSize)
+			if db.DatabaseType != "Test-Country" {
+				t.Errorf("DatabaseType = %q", db.DatabaseType)
+			}
+
+			for _, tt := range tests {
+				want := tt.want
+				if version == 4 && netip.MustParseAddr(tt.addr).Is6() && !netip.MustParseAddr(tt.addr).Is4In6() {
+					want = ""
+				}
+				if got := db.Country(netip.MustParseAddr(tt.addr)); got != want {
+					t.Errorf("IPv%d, %d bit records: Country(%s) = %q, want %q", version, recordSize, tt.addr, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLookupRecord(t *testing.T) {
+	db := newTestDB(t, 6, 24)
+
+	record, err := db.Lookup(netip.MustParseAddr("1.2.3.4"))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if got := lookupString(record, "country", "iso_code"); got != "DE" {
+		t.Errorf("iso_code = %q, want DE", got)
+	}
+	country := record.(map[string]any)["country"].(map[string]any)
+	if got := country["geoname_id"]; got != uint64(2921044) {
+		t.Errorf("geoname_id = %v (%T), want 2921044", got, got)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	valid := func(recordSize int, metadata map[string][]byte) []byte {
+		w := newMMDBWriter(4, recordSize)
+		w.insert(netip.MustParsePrefix("1.0.0.0/8"), w.addData(countryRecord("country", "AU")))
+		return w.bytes(metadata)
+	}
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"no metadata", []byte("not a database")},
+		{"metadata not a map", append(append([]byte{}, metadataMarker...), encodeString("x")...)},
+		{"truncated metadata", valid(24, nil)[:len(valid(24, nil))-3]},
+		{"unsupported record size", valid(24, map[string][]byte{"record_size": encodeUint(5, 20)})},
+		{"tree larger than the file", valid(24, map[string][]byte{"node_count": encodeUint(6, 1<<20)})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.buf); err == nil {
+				t.Error("New accepted an invalid database")
+			}
+		})
+	}
+
+	if _, err := New([]byte("no marker here")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidDatabase)
+	}
+}
+
+func TestLookupCorruptData(t *testing.T) {
+	w := newMMDBWriter(4, 24)
+	// The record points at itself, decoding must give up instead of
+	// recursing forever.
+	w.insert(netip.MustParsePrefix("1.0.0.0/8"), w.addData(encodePointer(0)))
+	// The record claims a longer string than the data section holds.
+	w.insert(netip.MustParsePrefix("2.0.0.0/8"), w.addData([]byte{2<<5 | 20, 'a'}))
+
+	db, err := New(w.bytes(nil))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, addr := range []string{"1.1.1.1", "2.2.2.2"} {
+		if _, err := db.Lookup(netip.MustParseAddr(addr)); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("Lookup(%s) error = %v, want %v", addr, err, ErrInvalidDatabase)
+		}
+		if got := db.Country(netip.MustParseAddr(addr)); got != "" {
+			t.Errorf("Country(%s) = %q, want none", addr, got)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+// Package metadata reads the title, description, Open Graph tags and
+// favicon of a web page.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	ErrNotHTML          = errors.New("metadata: destination is not an HTML page")
+	ErrUnexpectedStatus = errors.New("metadata: unexpected status")
+)
+
+// Limits on what is kept from a page, anything longer is cut.
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxPropertyLength    = 2048
+	maxProperties        = 50
+)
+
+type Metadata struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Image is og:image made absolute.
+	Image      string `json:"image,omitempty"`
+	FaviconUrl string `json:"favicon_url,omitempty"`
+	// OpenGraph holds every og: property of the page by name, og:image
+	// as found on the page.
+	OpenGraph map[string]string `json:"open_graph,omitempty"`
+}
+
+// Fetcher downloads pages through client, which should carry the time
+// limit. At most maxBytes of a page are read, the head of a page is almost
+// always well within that.
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+	maxBytes  int64
+}
+
+func NewFetcher(client *http.Client, userAgent string, maxBytes int64) *Fetcher {
+	return &Fetcher{
+		client:    client,
+		userAgent: userAgent,
+		maxBytes:  maxBytes,
+	}
+}
+
+// Fetch downloads rawUrl and reads its metadata. Relative URLs are resolved
+// against the page the redirects ended on.
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w %s", ErrUnexpectedStatus, resp.Status)
+	}
+
+	charset := ""
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err == nil && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+			return nil, ErrNotHTML
+		}
+		charset = params["charset"]
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data, charset, resp.Request.URL), nil
+}
+
+// Parse reads the metadata of an HTML document. charset is the one the
+// server declared, if any, the document's own declaration is used
+// otherwise. base resolves relative URLs.
+func Parse(data []byte, charset string, base *url.URL) *Metadata {
+	found := scan(data)
+	if charset == "" {
+		charset = found.charset
+	}
+
+	if charset != "" && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "utf8") {
+		if encoding, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := encoding.NewDecoder().Bytes(data); err == nil {
+				found = scan(decoded)
+			}
+		}
+	}
+
+	if found.base != "" && base != nil {
+		if ref, err := base.Parse(found.base); err == nil {
+			base = ref
+		}
+	}
+
+	m := &Metadata{
+		Title:       truncate(found.title, maxTitleLength),
+		Description: truncate(found.description, maxDescriptionLength),
+		FaviconUrl:  resolve(base, found.icon),
+	}
+	if m.FaviconUrl == "" {
+		m.FaviconUrl = resolve(base, "/favicon.ico")
+	}
+
+	if len(found.openGraph) > 0 {
+		m.OpenGraph = found.openGraph
+		m.Image = resolve(base, found.openGraph["og:image"])
+	}
+
+	return m
+}
+
+// resolve returns ref as an absolute http or https URL, or an empty string
+// when it can't be one.
+func resolve(base *url.URL, ref string) string {
+	if ref == "" || base == nil {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	// Cut on a rune boundary.
+	for n > 0 && !validStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+func validStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// page is what scan found in a document.
+type page struct {
+	title       string
+	description string
+	icon        string
+	iconRank    int
+	base        string
+	charset     string
+	openGraph   map[string]string
+}
+
+// scan walks the tags of the document head. It is no full HTML parser: it
+// only knows enough to find title, meta, link and base tags and to skip
+// comments, scripts and styles, and it stops where the body starts.
+func scan(data []byte) page {
+	var p page
+	s := string(data)
+
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 || start+1 >= len(s) {
+			return p
+		}
+		s = s[start:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				return p
+			}
+			s = s[end+3:]
+			continue
+		case s[1] == '!' || s[1] == '?':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return p
+			}
+			s = s[end+1:]
+			continue
+		case s[1] == '/':
+			name, rest := tagName(s[2:])
+			if name == "head" {
+				return p
+			}
+			s = rest
+			continue
+		}
+
+		name, rest := tagName(s[1:])
+		if name == "" {
+			s = s[1:]
+			continue
+		}
+
+		attrs, rest := attributes(rest)
+		s = rest
+
+		switch name {
+		case "body":
+			return p
+		case "script", "style", "template":
+			s = skipRawText(s, name)
+		case "title":
+			text := s
+			s = skipRawText(s, name)
+			text = text[:len(text)-len(s)]
+			if end := lastIndexFold(text, "</title"); end >= 0 {
+				text = text[:end]
+			}
+			if p.title == "" {
+				p.title = cleanText(text)
+			}
+		case "base":
+			if p.base == "" {
+				p.base = strings.TrimSpace(attrs["href"])
+			}
+		case "meta":
+			p.meta(attrs)
+		case "link":
+			p.link(attrs)
+		}
+	}
+}
+
+func (p *page) meta(attrs map[string]string) {
+	if charset := attrs["charset"]; charset != "" && p.charset == "" {
+		p.charset = strings.TrimSpace(charset)
+	}
+	if strings.EqualFold(attrs["http-equiv"], "content-type") && p.charset == "" {
+		if _, params, err := mime.ParseMediaType(attrs["content"]); err == nil {
+			p.charset = params["charset"]
+		}
+	}
+
+	content, ok := attrs["content"]
+	if !ok {
+		return
+	}
+
+	if strings.EqualFold(attrs["name"], "description") && p.description == "" {
+		p.description = cleanText(content)
+	}
+
+	property := strings.ToLower(strings.TrimSpace(attrs["property"]))
+	if !strings.HasPrefix(property, "og:") {
+		return
+	}
+	if p.openGraph == nil {
+		p.openGraph = make(map[string]string)
+	}
+	if _, seen := p.openGraph[property]; !seen && len(p.openGraph) < maxProperties {
+		p.openGraph[property] = truncate(cleanText(content), maxPropertyLength)
+	}
+}
+
+// link keeps the best icon, an icon or shortcut icon over an
+// apple-touch-icon.
+func (p *page) link(attrs map[string]string) {
+	href := strings.TrimSpace(attrs["href"])
+	if href == "" {
+		return
+	}
+
+	rank := 0
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		switch rel {
+		case "icon":
+			rank = max(rank, 2)
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			rank = max(rank, 1)
+		}
+	}
+
+	if rank > p.iconRank {
+		p.icon, p.iconRank = href, rank
+	}
+}
+
+// tagName reads a lower-cased tag name from the start of s.
+func tagName(s string) (string, string) {
+	i := 0
+	for i < len(s) && isNameByte(s[i]) {
+		i++
+	}
+
+	return strings.ToLower(s[:i]), s[i:]
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == ':'
+}
+
+// attributes reads the attributes of a tag up to and including its closing
+// '>'. Names are lower-cased and values unescaped, the first of repeated
+// attributes wins.
+func attributes(s string) (map[string]string, string) {
+	attrs := make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t\r\n\f/")
+		if s == "" {
+			return attrs, s
+		}
+		if s[0] == '>' {
+			return attrs, s[1:]
+		}
+
+		i := 0
+		for i < len(s) && !strings.ContainsRune(" \t\r\n\f/>=", rune(s[i])) {
+			i++
+		}
+		if i == 0 {
+			// A stray '=' or similar, skip it.
+			s = s[1:]
+			continue
+		}
+		name := strings.ToLower(s[:i])
+		s = strings.TrimLeft(s[i:], " \t\r\n\f")
+
+		value := ""
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\r\n\f")
+			if s != "" && (s[0] == '"' || s[0] == '\'') {
+				end := strings.IndexByte(s[1:], s[0])
+				if end < 0 {
+					return attrs, ""
+				}
+				value, s = s[1:end+1], s[end+2:]
+			} else {
+				j := 0
+				for j < len(s) && !strings.ContainsRune(" \t\r\n\f>", rune(s[j])) {
+					j++
+				}
+				value, s = s[:j], s[j:]
+			}
+		}
+
+		if _, ok := attrs[name]; !ok {
+			attrs[name] = unescape(value)
+		}
+	}
+}
+
+// skipRawText returns s past the end tag of name, or "" when it has none.
+func skipRawText(s, name string) string {
+	end := indexFold(s, "</"+name)
+	if end < 0 {
+		return ""
+	}
+	s = s[end:]
+
+	if close := strings.IndexByte(s, '>'); close >= 0 {
+		return s[close+1:]
+	}
+
+	return ""
+}
+
+// indexFold finds the lower-case ASCII substr in s ignoring case. Unlike
+// strings.ToLower, lowerASCII keeps every byte in place.
+func indexFold(s, substr string) int {
+	return strings.Index(lowerASCII(s), substr)
+}
+
+func lastIndexFold(s, substr string) int {
+	return strings.LastIndex(lowerASCII(s), substr)
+}
+
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+
+	return string(b)
+}
+
+// cleanText unescapes s and collapses its whitespace.
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(unescape(s)), " ")
+}
+
+func unescape(s string) string {
+	return html.UnescapeString(s)
+}
@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>
+		Tom &amp; Jerry   Returns
+	</title>
+	<meta name="description" content="A cat, a mouse &mdash; and a house.">
+	<meta property="og:title" content="Tom and Jerry">
+	<meta property="og:type" content="article">
+	<meta property="og:image" content="/images/cover.png">
+	<link rel="apple-touch-icon" href="/touch.png">
+	<link rel="icon" href="static/favicon.svg">
+</head>
+<body>
+	<title>Not the page title</title>
+</body>
+</html>`
+
+func newTestFetcher(client *http.Client, maxBytes int64) *Fetcher {
+	return NewFetcher(client, "yaurl-test", maxBytes)
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/42", http.StatusFound)
+	})
+	mux.HandleFunc("/articles/42", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "yaurl-test" {
+			t.Errorf("User-Agent = %q, want yaurl-test", got)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(articlePage))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	m, err := newTestFetcher(server.Client(), 1<<20).Fetch(context.Background(), server.URL+"/start")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	want := Metadata{
+		Title:       "Tom & Jerry Returns",
+		Description: "A cat, a mouse — and a house.",
+		Image:       server.URL + "/images/cover.png",
+		FaviconUrl:  server.URL + "/articles/static/favicon.svg",
+	}
+	if m.Title != want.Title || m.Description != want.Description || m.Image != want.Image || m.FaviconUrl != want.FaviconUrl {
+		t.Errorf("Fetch = %+v, want %+v", *m, want)
+	}
+
+	if got := m.OpenGraph["og:type"]; got != "article" {
+		t.Errorf("og:type = %q, want article", got)
+	}
+	if got := m.OpenGraph["og:image"]; got != "/images/cover.png" {
+		t.Errorf("og:image = %q, want the value from the page", got)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := server.Client()
+	client.Timeout = 100 * time.Millisecond
+	fetcher := newTestFetcher(client, 1<<20)
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"/missing", ErrUnexpectedStatus},
+		{"/image", ErrNotHTML},
+		{"/slow", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			m, err := fetcher.Fetch(context.Background(), server.URL+tt.path)
+			if err == nil {
+				t.Fatalf("Fetch = %+v, want an error", m)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Fetch error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><meta name=description content=early>"))
+		w.Write([]byte(strings.Repeat("<!-- padding -->", 1024)))
+		w.Write([]byte("<title>Too late</title></head></html>"))
+	}))
+	defer server.Close()
+
+	m, err := newTestFetcher(server.Client(), 4096).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if m.Description != "early" {
+		t.Errorf("Description = %q, want early", m.Description)
+	}
+	if m.Title != "" {
+		t.Errorf("Title = %q, want it past the size limit", m.Title)
+	}
+}
+
+func TestFetchCharset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<meta http-equiv=\"Content-Type\" content=\"text/html; charset=windows-1252\"><title>Caf\xe9</title>"))
+	}))
+	defer server.Close()
+
+	m, err := newTestFetcher(server.Client(), 1<<20).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if m.Title != "Café" {
+		t.Errorf("Title = %q, want Café", m.Title)
+	}
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+
+	tests := []struct {
+		name string
+		html string
+		want Metadata
+	}{
+		{
+			name: "comments and scripts are skipped",
+			html: `<head><!-- <title>Commented</title> --><script>document.write("<title>Scripted</title>")</script><TITLE>Real</TITLE>`,
+			want: Metadata{Title: "Real", FaviconUrl: "https://example.com/favicon.ico"},
+		},
+		{
+			name: "base href and touch icon",
+			html: `<head><base href="https://cdn.example.net/assets/"><link rel=apple-touch-icon href=icon.png><meta property=og:image content=cover.jpg>`,
+			want: Metadata{
+				Image:      "https://cdn.example.net/assets/cover.jpg",
+				FaviconUrl: "https://cdn.example.net/assets/icon.png",
+			},
+		},
+		{
+			name: "only http links are kept",
+			html: `<head><link rel="shortcut icon" href="javascript:alert(1)"><meta property="og:image" content="data:image/png;base64,AAAA">`,
+			want: Metadata{FaviconUrl: "https://example.com/favicon.ico"},
+		},
+		{
+			name: "body ends the head",
+			html: `<html><body><meta name="description" content="too late"><title>Too late</title>`,
+			want: Metadata{FaviconUrl: "https://example.com/favicon.ico"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Parse([]byte(tt.html), "", base)
+			if m.Title != tt.want.Title || m.Description != tt.want.Description || m.Image != tt.want.Image || m.FaviconUrl != tt.want.FaviconUrl {
+				t.Errorf("Parse = %+v, want %+v", *m, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTruncatesOnRuneBoundary(t *testing.T) {
+	title := strings.Repeat("é", maxTitleLength)
+
+	m := Parse([]byte("<title>"+title+"</title>"), "utf-8", nil)
+	if len(m.Title) > maxTitleLength || !strings.HasPrefix(title, m.Title) || len(m.Title)%2 != 0 {
+		t.Errorf("Title was cut to %d bytes, want at most %d on a rune boundary", len(m.Title), maxTitleLength)
+	}
+}
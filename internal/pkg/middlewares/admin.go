@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/badiwidya/yaurl/internal/pkg/utils"
+)
+
+// NewAdminRequired only lets administrators through. It must be wrapped by
+// the middleware returned from NewAuthRequired, which sets UserKey.
+func NewAdminRequired(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, ok := r.Context().Value(UserKey).(int)
+			if !ok {
+				utils.JSONResponse(w, http.StatusUnauthorized, &utils.Response{
+					Message: "Unauthorized",
+				})
+				return
+			}
+
+			var isAdmin bool
+			row := db.QueryRowContext(r.Context(), "SELECT is_admin FROM users WHERE id = $1", userId)
+			if err := row.Scan(&isAdmin); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+					Message: "Internal server error",
+				})
+				return
+			}
+
+			if !isAdmin {
+				utils.JSONResponse(w, http.StatusForbidden, &utils.Response{
+					Message: "Forbidden",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,558 @@
+// Package qrcode encodes data as QR Code symbols (ISO/IEC 18004) in byte
+// mode. It follows Project Nayuki's reference QR Code generator, trimmed
+// down to what short links need.
+package qrcode
+
+import (
+	"errors"
+)
+
+type ECCLevel int
+
+// Error correction levels, from about 7% to about 30% of the symbol being
+// recoverable.
+const (
+	Low ECCLevel = iota
+	Medium
+	Quartile
+	High
+)
+
+var ErrTooLong = errors.New("qrcode: data too long")
+var ErrInvalidLevel = errors.New("qrcode: invalid error correction level")
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// Code is an encoded QR Code symbol, without its quiet zone.
+type Code struct {
+	version int
+	level   ECCLevel
+	size    int
+
+	modules    []bool
+	isFunction []bool
+}
+
+// Encode returns the smallest symbol that holds data at the given level.
+func Encode(data []byte, level ECCLevel) (*Code, error) {
+	if level < Low || level > High {
+		return nil, ErrInvalidLevel
+	}
+
+	version := minVersion
+	for ; ; version++ {
+		if 4+charCountBits(version)+len(data)*8 <= numDataCodewords(version, level)*8 {
+			break
+		}
+		if version == maxVersion {
+			return nil, ErrTooLong
+		}
+	}
+
+	var bb bitBuffer
+	bb.append(0x4, 4) // byte mode
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version, level) * 8
+	bb.append(0, min(4, capacity-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	return newCode(version, level, codewords), nil
+}
+
+// Size returns the number of modules along each side.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark reports whether the module at column x, row y is dark. Coordinates
+// outside the symbol are light.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.size || y >= c.size {
+		return false
+	}
+
+	return c.modules[y*c.size+x]
+}
+
+func newCode(version int, level ECCLevel, dataCodewords []byte) *Code {
+	size := version*4 + 17
+	c := &Code{
+		version:    version,
+		level:      level,
+		size:       size,
+		modules:    make([]bool, size*size),
+		isFunction: make([]bool, size*size),
+	}
+
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addECCAndInterleave(dataCodewords))
+
+	bestMask, minPenalty := 0, -1
+	for mask := range 8 {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if penalty := c.penaltyScore(); minPenalty < 0 || penalty < minPenalty {
+			bestMask, minPenalty = mask, penalty
+		}
+		c.applyMask(mask) // XOR again to undo
+	}
+
+	c.applyMask(bestMask)
+	c.drawFormatBits(bestMask)
+	c.isFunction = nil
+
+	return c
+}
+
+func (c *Code) setFunctionModule(x, y int, dark bool) {
+	c.modules[y*c.size+x] = dark
+	c.isFunction[y*c.size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := range c.size {
+		c.setFunctionModule(6, i, i%2 == 0)
+		c.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.size-4, 3)
+	c.drawFinderPattern(3, c.size-4)
+
+	positions := alignmentPatternPositions(c.version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The three corners taken by finder patterns are skipped.
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// Reserve the format areas now, the real bits are drawn per mask.
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.size || yy >= c.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunctionModule(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunctionModule(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInfo(c.level, mask)
+
+	// Around the top left finder pattern.
+	for i := 0; i <= 5; i++ {
+		c.setFunctionModule(8, i, bit(bits, i))
+	}
+	c.setFunctionModule(8, 7, bit(bits, 6))
+	c.setFunctionModule(8, 8, bit(bits, 7))
+	c.setFunctionModule(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunctionModule(14-i, 8, bit(bits, i))
+	}
+
+	// Split between the other two finder patterns.
+	for i := range 8 {
+		c.setFunctionModule(c.size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunctionModule(8, c.size-15+i, bit(bits, i))
+	}
+	c.setFunctionModule(8, c.size-8, true)
+}
+
+func (c *Code) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+
+	bits := versionInfo(c.version)
+
+	for i := range 18 {
+		dark := bit(bits, i)
+		a, b := c.size-11+i%3, i/3
+		c.setFunctionModule(a, b, dark)
+		c.setFunctionModule(b, a, dark)
+	}
+}
+
+// addECCAndInterleave splits the data into blocks, appends Reed-Solomon
+// error correction to each and interleaves the result.
+func (c *Code) addECCAndInterleave(data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[c.level][c.version]
+	blockECCLen := eccCodewordsPerBlock[c.level][c.version]
+	rawCodewords := numRawDataModules(c.version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		dataLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+dataLen]...)
+		ecc := reedSolomonRemainder(data[k:k+dataLen], divisor)
+		k += dataLen
+		if i < numShortBlocks {
+			// Placeholder so every block has the same length, skipped below.
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range shortBlockLen + 1 {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
+
+// drawCodewords fills the non-function modules in the zigzag order,
+// two columns at a time from the bottom right corner.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range c.size {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := range 2 {
+				x := right - j
+				if c.isFunction[y*c.size+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*c.size+x] = bit(int(data[i>>3]), 7-i&7)
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := range c.size {
+		for x := range c.size {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y*c.size+x] {
+				c.modules[y*c.size+x] = !c.modules[y*c.size+x]
+			}
+		}
+	}
+}
+
+const (
+	penaltyN1 = 3
+	penaltyN2 = 3
+	penaltyN3 = 40
+	penaltyN4 = 10
+)
+
+// penaltyScore rates how hard the current masked symbol is to scan, lower
+// is better.
+func (c *Code) penaltyScore() int {
+	result := 0
+
+	for _, columns := range []bool{false, true} {
+		for a := range c.size {
+			runDark, runLength := false, 0
+			var history [7]int
+			for b := range c.size {
+				dark := c.modules[a*c.size+b]
+				if columns {
+					dark = c.modules[b*c.size+a]
+				}
+				if dark == runDark {
+					runLength++
+					if runLength == 5 {
+						result += penaltyN1
+					} else if runLength > 5 {
+						result++
+					}
+					continue
+				}
+				c.addRunHistory(runLength, &history)
+				if !runDark {
+					result += countFinderLikePatterns(&history) * penaltyN3
+				}
+				runDark, runLength = dark, 1
+			}
+			result += c.terminateRunHistory(runDark, runLength, &history) * penaltyN3
+		}
+	}
+
+	for y := range c.size - 1 {
+		for x := range c.size - 1 {
+			dark := c.modules[y*c.size+x]
+			if dark == c.modules[y*c.size+x+1] && dark == c.modules[(y+1)*c.size+x] && dark == c.modules[(y+1)*c.size+x+1] {
+				result += penaltyN2
+			}
+		}
+	}
+
+	dark := 0
+	for _, m := range c.modules {
+		if m {
+			dark++
+		}
+	}
+	total := c.size * c.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * penaltyN4
+
+	return result
+}
+
+func (c *Code) addRunHistory(runLength int, history *[7]int) {
+	if history[0] == 0 {
+		// The light quiet zone extends the first run.
+		runLength += c.size
+	}
+	copy(history[1:], history[:6])
+	history[0] = runLength
+}
+
+func (c *Code) terminateRunHistory(runDark bool, runLength int, history *[7]int) int {
+	if runDark {
+		c.addRunHistory(runLength, history)
+		runLength = 0
+	}
+	c.addRunHistory(runLength+c.size, history)
+
+	return countFinderLikePatterns(history)
+}
+
+// countFinderLikePatterns counts 1:1:3:1:1 runs with light space on
+// either side in the run history.
+func countFinderLikePatterns(history *[7]int) int {
+	n := history[1]
+	core := n > 0 && history[2] == n && history[3] == n*3 && history[4] == n && history[5] == n
+
+	count := 0
+	if core && history[0] >= n*4 && history[6] >= n {
+		count++
+	}
+	if core && history[6] >= n*4 && history[0] >= n {
+		count++
+	}
+
+	return count
+}
+
+func (l ECCLevel) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// formatInfo returns the 15 format bits for level and mask: five data bits,
+// a BCH(15,5) remainder and the fixed XOR mask.
+func formatInfo(level ECCLevel, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for range 10 {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionInfo returns the 18 version bits of versions 7 and up: six data
+// bits followed by a BCH(18,6) remainder.
+func versionInfo(version int) int {
+	rem := version
+	for range 12 {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	return version<<12 | rem
+}
+
+func alignmentPatternPositions(v int) []int {
+	if v == 1 {
+		return nil
+	}
+
+	numAlign := v/7 + 2
+	step := (v*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, v*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// numRawDataModules is the number of modules left for data and error
+// correction once every function pattern is drawn.
+func numRawDataModules(v int) int {
+	result := (16*v+128)*v + 64
+	if v >= 2 {
+		numAlign := v/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if v >= 7 {
+			result -= 36
+		}
+	}
+
+	return result
+}
+
+func numDataCodewords(v int, level ECCLevel) int {
+	return numRawDataModules(v)/8 - eccCodewordsPerBlock[level][v]*numErrorCorrectionBlocks[level][v]
+}
+
+func charCountBits(v int) int {
+	if v <= 9 {
+		return 8
+	}
+
+	return 16
+}
+
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numErrorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// highest coefficient first with the leading 1 omitted.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for range degree {
+		for j := range result {
+			result[j] = reedSolomonMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = reedSolomonMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= reedSolomonMultiply(coef, factor)
+		}
+	}
+
+	return result
+}
+
+// reedSolomonMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func reedSolomonMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, bit(value, i))
+	}
+}
+
+func bit(x, i int) bool {
+	return (x>>i)&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,155 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomonDivisor(t *testing.T) {
+	// x^7 + 127x^6 + 122x^5 + 154x^4 + 164x^3 + 11x^2 + 68x + 117
+	want := []byte{127, 122, 154, 164, 11, 68, 117}
+
+	if got := reedSolomonDivisor(7); !slices.Equal(got, want) {
+		t.Errorf("reedSolomonDivisor(7) = %v, want %v", got, want)
+	}
+}
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// The data codewords of "HELLO WORLD" as a 1-M symbol and their error
+	// correction codewords.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(len(want))); !slices.Equal(got, want) {
+		t.Errorf("reedSolomonRemainder = %v, want %v", got, want)
+	}
+}
+
+func TestFormatInfo(t *testing.T) {
+	tests := []struct {
+		level ECCLevel
+		mask  int
+		want  string
+	}{
+		{Low, 0, "111011111000100"},
+		{Low, 7, "110100101110110"},
+		{Medium, 0, "101010000010010"},
+		{Medium, 5, "100000011001110"},
+		{Quartile, 2, "011111100110001"},
+		{Quartile, 6, "010111011011010"},
+		{High, 3, "001100111010000"},
+		{High, 7, "000100000111011"},
+	}
+
+	for _, tt := range tests {
+		want, _ := strconv.ParseInt(tt.want, 2, 32)
+		if got := formatInfo(tt.level, tt.mask); got != int(want) {
+			t.Errorf("formatInfo(%d, %d) = %015b, want %s", tt.level, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+		{10, "001010010011010011"},
+		{40, "101000110001101001"},
+	}
+
+	for _, tt := range tests {
+		want, _ := strconv.ParseInt(tt.want, 2, 32)
+		if got := versionInfo(tt.version); got != int(want) {
+			t.Errorf("versionInfo(%d) = %018b, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+// referenceSymbol is "yaurl.io/Ab3" as a 1-M symbol with mask 6, as drawn
+// by Kazuhiko Arase's QR Code generator.
+var referenceSymbol = []string{
+	"#######.#####.#######",
+	"#.....#.###...#.....#",
+	"#.###.#.#...#.#.###.#",
+	"#.###.#..#.#..#.###.#",
+	"#.###.#.#.#.#.#.###.#",
+	"#.....#..#.##.#.....#",
+	"#######.#.#.#.#######",
+	"...........##........",
+	"#..######...##..#.###",
+	"#.#.....#.#.##....#..",
+	"##...###.....#....###",
+	"#.###..###.#...##.##.",
+	"###.#.#.##...#.#...#.",
+	"........###.#...###..",
+	"#######.##..#.#.###..",
+	"#.....#.####.##..##.#",
+	"#.###.#.#..##.#.#..#.",
+	"#.###.#.###......##..",
+	"#.###.#..#.##.####.##",
+	"#.....#..##...#..####",
+	"#######.##..###......",
+}
+
+func TestEncodeMatchesReference(t *testing.T) {
+	code, err := Encode([]byte("yaurl.io/Ab3"), Medium)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	if code.Size() != len(referenceSymbol) {
+		t.Fatalf("Size = %d, want %d", code.Size(), len(referenceSymbol))
+	}
+
+	for y, want := range referenceSymbol {
+		var row strings.Builder
+		for x := range code.Size() {
+			if code.Dark(x, y) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		if got := row.String(); got != want {
+			t.Errorf("row %d = %s, want %s", y, got, want)
+		}
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		length  int
+		level   ECCLevel
+		version int
+	}{
+		{14, Medium, 1},
+		{15, Medium, 2},
+		{17, Low, 1},
+		{7, High, 1},
+		{2953, Low, 40},
+	}
+
+	for _, tt := range tests {
+		code, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+		if err != nil {
+			t.Fatalf("Encode(%d bytes, %d): %v", tt.length, tt.level, err)
+		}
+		if code.version != tt.version {
+			t.Errorf("Encode(%d bytes, %d) is version %d, want %d", tt.length, tt.level, code.version, tt.version)
+		}
+	}
+
+	if _, err := Encode(bytes.Repeat([]byte("a"), 2954), Low); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode past version 40 = %v, want ErrTooLong", err)
+	}
+	if _, err := Encode([]byte("a"), High+1); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("Encode with an unknown level = %v, want ErrInvalidLevel", err)
+	}
+}
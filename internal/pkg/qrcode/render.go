@@ -0,0 +1,80 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// RenderOptions controls how a symbol is drawn. Size is the width and
+// height of the image in pixels and Margin the quiet zone in modules.
+type RenderOptions struct {
+	Size       int
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// WritePNG draws the symbol as a two colour PNG of exactly opts.Size
+// pixels, or of one pixel per module when opts.Size is smaller than that.
+func (c *Code) WritePNG(w io.Writer, opts RenderOptions) error {
+	modules := c.size + 2*opts.Margin
+	size := max(opts.Size, modules)
+
+	palette := color.Palette{opts.Background, opts.Foreground}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+
+	for py := range size {
+		y := py*modules/size - opts.Margin
+		for px := range size {
+			x := px*modules/size - opts.Margin
+			if c.Dark(x, y) {
+				img.Pix[py*img.Stride+px] = 1
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// WriteSVG draws the symbol as an SVG document with a single path for the
+// dark modules, scaled to opts.Size pixels.
+func (c *Code) WriteSVG(w io.Writer, opts RenderOptions) error {
+	modules := c.size + 2*opts.Margin
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(
+		bw,
+		`<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+			`<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		opts.Size, opts.Size, modules, modules,
+	)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hexColor(opts.Background))
+	fmt.Fprintf(bw, `<path fill="%s" d="`, hexColor(opts.Foreground))
+
+	first := true
+	for y := range c.size {
+		for x := range c.size {
+			if !c.Dark(x, y) {
+				continue
+			}
+			if !first {
+				bw.WriteByte(' ')
+			}
+			first = false
+			fmt.Fprintf(bw, "M%d,%dh1v1h-1z", x+opts.Margin, y+opts.Margin)
+		}
+	}
+
+	bw.WriteString("\"/>\n</svg>\n")
+
+	return bw.Flush()
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
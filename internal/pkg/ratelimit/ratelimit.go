@@ -0,0 +1,93 @@
+// Package ratelimit keeps per-key attempt counters in fixed time windows.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxKeys bounds the number of tracked keys. Once it is reached new keys
+// are refused until Sweep drops expired windows, old keys keep working.
+const maxKeys = 1 << 16
+
+// Limiter allows at most limit attempts per key within each window. A
+// limit of zero or less disables it. Expired windows stay around until
+// Sweep is called, which should happen about once per window.
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+type window struct {
+	count   int
+	resetAt time.Time
+}
+
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		window:  period,
+		windows: make(map[string]*window),
+	}
+}
+
+// Take reserves an attempt for key. When none are left it reports false
+// together with the time until the window resets.
+func (l *Limiter) Take(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok && len(l.windows) >= maxKeys {
+		return false, l.window
+	}
+	if !ok || !now.Before(w.resetAt) {
+		w = &window{resetAt: now.Add(l.window)}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.resetAt.Sub(now)
+	}
+	w.count++
+
+	return true, 0
+}
+
+// Refund gives back an attempt taken for key, for attempts that turned out
+// not to count, e.g. because they succeeded.
+func (l *Limiter) Refund(key string) {
+	if l.limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if w, ok := l.windows[key]; ok && w.count > 0 {
+		w.count--
+	}
+}
+
+// Sweep drops the windows that have expired.
+func (l *Limiter) Sweep() {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, w := range l.windows {
+		if !now.Before(w.resetAt) {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package reputation
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// canonicalURL is a URL canonicalized the way Safe Browsing hashes them.
+type canonicalURL struct {
+	host  string
+	path  string
+	query string
+	// hasQuery is set when the URL has a ?, even with an empty query.
+	hasQuery bool
+	isIP     bool
+}
+
+// controlStripper drops tabs and line breaks. It works on bytes, so
+// invalid UTF-8 is left alone rather than replaced.
+var controlStripper = strings.NewReplacer("\t", "", "\r", "", "\n", "")
+
+// canonicalize follows the Safe Browsing rules closely enough for lookups:
+// fragments are dropped, the URL is unescaped until stable, the host is
+// lower-cased with its dots trimmed and collapsed and IPv4 addresses are
+// written as four decimal parts, dot segments and repeated slashes are
+// removed from the path, and the result is escaped again. The URL is split
+// by hand rather than with url.Parse, which rejects the escaped and
+// malformed hosts the rules have to handle.
+func canonicalize(rawUrl string) (canonicalURL, bool) {
+	rawUrl = controlStripper.Replace(strings.TrimSpace(rawUrl))
+	rawUrl, _, _ = strings.Cut(rawUrl, "#")
+
+	// URLs without a scheme are read as http ones.
+	if i := strings.Index(rawUrl, "://"); i >= 0 && !strings.ContainsAny(rawUrl[:i], "/?") {
+		rawUrl = rawUrl[i+3:]
+	}
+
+	authority, rest := rawUrl, ""
+	if i := strings.IndexAny(rawUrl, "/?"); i >= 0 {
+		authority, rest = rawUrl[:i], rawUrl[i:]
+	}
+	path, query, hasQuery := strings.Cut(rest, "?")
+
+	host := hostname(authority)
+	host = lowerASCII(unescapeFully(host))
+	host = strings.Trim(host, ".")
+	for strings.Contains(host, "..") {
+		host = strings.ReplaceAll(host, "..", ".")
+	}
+	if host == "" {
+		return canonicalURL{}, false
+	}
+
+	isIP := false
+	if addr, ok := parseIPv4(host); ok {
+		host, isIP = addr, true
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		_, err := netip.ParseAddr(host[1 : len(host)-1])
+		isIP = err == nil
+	}
+
+	c := canonicalURL{
+		host:     escape(host),
+		path:     escape(cleanPath(unescapeFully(path))),
+		query:    escape(unescapeFully(query)),
+		hasQuery: hasQuery,
+		isIP:     isIP,
+	}
+
+	return c, true
+}
+
+// lowerASCII lower-cases the ASCII letters of s. Unlike strings.ToLower
+// it keeps bytes that aren't valid UTF-8.
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+
+	return string(b)
+}
+
+// hostname strips the user info and port from authority.
+func hostname(authority string) string {
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+
+	if strings.HasPrefix(authority, "[") {
+		if i := strings.Index(authority, "]"); i >= 0 {
+			return authority[:i+1]
+		}
+		return authority
+	}
+
+	host, _, _ := strings.Cut(authority, ":")
+
+	return host
+}
+
+// parseIPv4 reads host as an IPv4 address in any form browsers accept,
+// such as 3279880203, 0xc3.0x7f.0.11 or 195.127.11, and returns it in
+// dotted decimal.
+func parseIPv4(host string) (string, bool) {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return "", false
+	}
+
+	numbers := make([]uint64, len(parts))
+	for i, part := range parts {
+		base := 10
+		switch {
+		case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+			part, base = part[2:], 16
+		case len(part) > 1 && part[0] == '0':
+			part, base = part[1:], 8
+		}
+
+		n, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return "", false
+		}
+		numbers[i] = n
+	}
+
+	last := len(numbers) - 1
+	if numbers[last] >= 1<<(8*(4-last)) {
+		return "", false
+	}
+	ipv4 := numbers[last]
+	for i, n := range numbers[:last] {
+		if n > 255 {
+			return "", false
+		}
+		ipv4 |= n << (8 * (3 - i))
+	}
+
+	return netip.AddrFrom4([4]byte{byte(ipv4 >> 24), byte(ipv4 >> 16), byte(ipv4 >> 8), byte(ipv4)}).String(), true
+}
+
+// unescapeFully percent-unescapes s until no escapes are left. A % that
+// doesn't start an escape is kept as it is.
+func unescapeFully(s string) string {
+	for range 16 {
+		unescaped := unescape(s)
+		if unescaped == s {
+			break
+		}
+		s = unescaped
+	}
+
+	return s
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+// escape percent-escapes control characters, spaces, non-ASCII bytes and
+// the characters # and %.
+func escape(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= 32 || c >= 127 || c == '#' || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	var segments []string
+	for segment := range strings.SplitSeq(p, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	cleaned := "/" + strings.Join(segments, "/")
+	if len(segments) > 0 && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// expressions returns the host suffix and path prefix combinations that
+// are hashed for a lookup: up to five hosts and six paths.
+func (c canonicalURL) expressions() []string {
+	hosts := []string{c.host}
+	if !c.isIP {
+		labels := strings.Split(c.host, ".")
+		start := max(len(labels)-5, 1)
+		for i := start; i < len(labels)-1; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	var paths []string
+	if c.hasQuery {
+		paths = append(paths, c.path+"?"+c.query)
+	}
+	paths = append(paths, c.path)
+
+	prefix := "/"
+	if c.path != "/" {
+		paths = append(paths, prefix)
+	}
+	segments := strings.Split(strings.Trim(c.path, "/"), "/")
+	for i := 0; i < len(segments)-1 && len(paths) < 6; i++ {
+		prefix += segments[i] + "/"
+		if prefix != c.path {
+			paths = append(paths, prefix)
+		}
+	}
+
+	expressions := make([]string, 0, len(hosts)*len(paths))
+	for _, host := range hosts {
+		for _, path := range paths {
+			expressions = append(expressions, host+path)
+		}
+	}
+
+	return expressions
+}
@@ -0,0 +1,184 @@
+// Package reputation matches URLs against locally stored threat lists,
+// either Safe Browsing hash-prefix lists or plain lists of hosts.
+package reputation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnsupportedList = errors.New("reputation: unsupported list format")
+
+// Match tells which list flagged a URL.
+type Match struct {
+	Source     string `json:"source"`
+	ThreatType string `json:"threat_type"`
+}
+
+func (m Match) String() string {
+	return m.ThreatType + " (" + m.Source + ")"
+}
+
+// List is the parsed content of one source.
+type List struct {
+	source string
+	hosts  map[string]string
+	// prefixes holds SHA-256 hash prefixes grouped by their length.
+	prefixes map[int]map[string]string
+}
+
+// Len returns the number of hosts and hash prefixes in l.
+func (l *List) Len() int {
+	n := len(l.hosts)
+	for _, set := range l.prefixes {
+		n += len(set)
+	}
+
+	return n
+}
+
+// Parse reads a source. JSON documents are read as Safe Browsing
+// threatListUpdates responses, anything else as a host list.
+func Parse(source string, data []byte) (*List, error) {
+	l := &List{
+		source:   source,
+		hosts:    make(map[string]string),
+		prefixes: make(map[int]map[string]string),
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := l.parseUpdate(trimmed); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	l.parseHosts(data)
+
+	return l, nil
+}
+
+// updateResponse is the part of a Safe Browsing v4 threatListUpdates:fetch
+// response needed for full updates with raw hashes.
+type updateResponse struct {
+	ListUpdateResponses []struct {
+		ThreatType   string `json:"threatType"`
+		ResponseType string `json:"responseType"`
+		Additions    []struct {
+			CompressionType string `json:"compressionType"`
+			RawHashes       *struct {
+				PrefixSize int    `json:"prefixSize"`
+				RawHashes  string `json:"rawHashes"`
+			} `json:"rawHashes"`
+		} `json:"additions"`
+	} `json:"listUpdateResponses"`
+}
+
+func (l *List) parseUpdate(data []byte) error {
+	var update updateResponse
+	if err := json.Unmarshal(data, &update); err != nil {
+		return fmt.Errorf("reputation: %w", err)
+	}
+
+	for _, list := range update.ListUpdateResponses {
+		if list.ResponseType == "PARTIAL_UPDATE" {
+			return fmt.Errorf("%w: partial updates need the previous state", ErrUnsupportedList)
+		}
+
+		threat := list.ThreatType
+		if threat == "" {
+			threat = "THREAT_TYPE_UNSPECIFIED"
+		}
+
+		for _, addition := range list.Additions {
+			if addition.RawHashes == nil {
+				continue
+			}
+			if addition.CompressionType != "" && addition.CompressionType != "RAW" {
+				return fmt.Errorf("%w: %s compression", ErrUnsupportedList, addition.CompressionType)
+			}
+
+			size := addition.RawHashes.PrefixSize
+			if size < 4 || size > sha256.Size {
+				return fmt.Errorf("%w: prefix size %d", ErrUnsupportedList, size)
+			}
+
+			raw, err := base64.StdEncoding.DecodeString(addition.RawHashes.RawHashes)
+			if err != nil || len(raw)%size != 0 {
+				return fmt.Errorf("%w: malformed raw hashes", ErrUnsupportedList)
+			}
+
+			set := l.prefixes[size]
+			if set == nil {
+				set = make(map[string]string)
+				l.prefixes[size] = set
+			}
+			for i := 0; i < len(raw); i += size {
+				set[string(raw[i:i+size])] = threat
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseHosts reads one host per line. Comments start with #, and lines in
+// hosts file form, such as "0.0.0.0 evil.example", are accepted too.
+func (l *List) parseHosts(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		host := fields[len(fields)-1]
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if host == "" || host == "localhost" || !strings.Contains(host, ".") {
+			continue
+		}
+
+		l.hosts[host] = "MALICIOUS_HOST"
+	}
+}
+
+// Check looks rawUrl up in l.
+func (l *List) Check(rawUrl string) (Match, bool) {
+	u, ok := canonicalize(rawUrl)
+	if !ok {
+		return Match{}, false
+	}
+
+	for host := u.host; ; {
+		if threat, ok := l.hosts[host]; ok {
+			return Match{Source: l.source, ThreatType: threat}, true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			break
+		}
+		host = parent
+	}
+
+	if len(l.prefixes) == 0 {
+		return Match{}, false
+	}
+
+	for _, expression := range u.expressions() {
+		hash := sha256.Sum256([]byte(expression))
+		for size, set := range l.prefixes {
+			if threat, ok := set[string(hash[:size])]; ok {
+				return Match{Source: l.source, ThreatType: threat}, true
+			}
+		}
+	}
+
+	return Match{}, false
+}
@@ -0,0 +1,166 @@
+package reputation
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// rawHashes encodes the first size bytes of the SHA-256 of each expression
+// the way threatListUpdates responses carry them.
+func rawHashes(size int, expressions ...string) string {
+	var raw []byte
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		raw = append(raw, hash[:size]...)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestListCheckHashPrefixes(t *testing.T) {
+	update := fmt.Sprintf(`{
+		"listUpdateResponses": [
+			{
+				"threatType": "MALWARE",
+				"responseType": "FULL_UPDATE",
+				"additions": [
+					{"compressionType": "RAW", "rawHashes": {"prefixSize": 4, "rawHashes": %q}}
+				]
+			},
+			{
+				"threatType": "SOCIAL_ENGINEERING",
+				"responseType": "FULL_UPDATE",
+				"additions": [
+					{"rawHashes": {"prefixSize": 32, "rawHashes": %q}}
+				]
+			}
+		]
+	}`, rawHashes(4, "evil.example/", "1.2.3.4/"), rawHashes(32, "b.c/1/"))
+
+	list, err := Parse("sb", []byte(update))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := list.Len(); got != 3 {
+		t.Errorf("Len = %d, want 3", got)
+	}
+
+	tests := []struct {
+		url    string
+		threat string
+	}{
+		{"https://evil.example/", "MALWARE"},
+		{"https://www.evil.example/some/page?x=1", "MALWARE"},
+		{"HTTPS://EVIL.example.:8443/%2e/a/../", "MALWARE"},
+		{"http://%65vil.example/", "MALWARE"},
+		{"http://3279880203.evil.example/", "MALWARE"},
+		{"http://1.2.3.4/anything", "MALWARE"},
+		{"http://0x01020304/", "MALWARE"},
+		{"http://a.b.c/1/2.html?param=1", "SOCIAL_ENGINEERING"},
+		{"http://a.b.c/1/", "SOCIAL_ENGINEERING"},
+		{"http://a.b.c/", ""},
+		{"http://a.b.c/2/1/", ""},
+		{"https://notevil.example/", ""},
+		{"https://evil.example.com/", ""},
+		{"http://5.6.7.8/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			match, ok := list.Check(tt.url)
+			if tt.threat == "" {
+				if ok {
+					t.Errorf("Check(%q) = %s, want no match", tt.url, match)
+				}
+				return
+			}
+			want := Match{Source: "sb", ThreatType: tt.threat}
+			if !ok || match != want {
+				t.Errorf("Check(%q) = %s, %v, want %s", tt.url, match, ok, want)
+			}
+		})
+	}
+}
+
+func TestListCheckHosts(t *testing.T) {
+	list, err := Parse("hosts", []byte(`
+# a hosts file
+0.0.0.0 bad.example
+127.0.0.1 localhost
+Phish.Example.  # trailing comment
+nodots
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := list.Len(); got != 2 {
+		t.Errorf("Len = %d, want 2", got)
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://bad.example/", true},
+		{"https://a.b.bad.example/path", true},
+		{"https://phish.example/login", true},
+		{"https://BAD.EXAMPLE./", true},
+		{"https://notbad.example/", false},
+		{"https://bad.example.org/", false},
+		{"http://localhost/", false},
+		{"http://nodots/", false},
+	}
+
+	for _, tt := range tests {
+		match, ok := list.Check(tt.url)
+		if ok != tt.want {
+			t.Errorf("Check(%q) = %s, %v, want %v", tt.url, match, ok, tt.want)
+		}
+		if ok && match.ThreatType != "MALICIOUS_HOST" {
+			t.Errorf("Check(%q) threat = %q", tt.url, match.ThreatType)
+		}
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+	}{
+		{
+			"partial update",
+			`{"listUpdateResponses": [{"responseType": "PARTIAL_UPDATE"}]}`,
+		},
+		{
+			"rice compression",
+			`{"listUpdateResponses": [{"additions": [{"compressionType": "RICE", "rawHashes": {"prefixSize": 4, "rawHashes": ""}}]}]}`,
+		},
+		{
+			"short prefix",
+			`{"listUpdateResponses": [{"additions": [{"rawHashes": {"prefixSize": 3, "rawHashes": "AAAA"}}]}]}`,
+		},
+		{
+			"truncated hashes",
+			`{"listUpdateResponses": [{"additions": [{"rawHashes": {"prefixSize": 4, "rawHashes": "AAAAAAA="}}]}]}`,
+		},
+		{
+			"bad base64",
+			`{"listUpdateResponses": [{"additions": [{"rawHashes": {"prefixSize": 4, "rawHashes": "!!!!"}}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse("sb", []byte(tt.update)); !errors.Is(err, ErrUnsupportedList) {
+				t.Errorf("Parse error = %v, want %v", err, ErrUnsupportedList)
+			}
+		})
+	}
+
+	if _, err := Parse("sb", []byte(`{"listUpdateResponses": [`)); err == nil {
+		t.Error("Parse accepted malformed JSON")
+	}
+}
@@ -0,0 +1,191 @@
+package reputation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxListSize bounds a downloaded list.
+const maxListSize = 256 << 20
+
+// Store keeps the lists of every configured source. Sources are URLs or
+// file paths. Downloads are copied to the cache directory, when one is
+// set, so the lists survive restarts and failed refreshes.
+type Store struct {
+	sources  []string
+	cacheDir string
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu    sync.RWMutex
+	lists map[string]*List
+}
+
+func NewStore(sources []string, cacheDir string, logger *slog.Logger) *Store {
+	return &Store{
+		sources:  sources,
+		cacheDir: cacheDir,
+		client:   &http.Client{Timeout: time.Minute},
+		logger:   logger,
+		lists:    make(map[string]*List),
+	}
+}
+
+// Enabled reports whether any source is configured.
+func (s *Store) Enabled() bool {
+	return s != nil && len(s.sources) > 0
+}
+
+// Refresh loads every source again. A source that fails keeps its previous
+// list, or falls back to its cached copy when it has none yet.
+func (s *Store) Refresh(ctx context.Context) error {
+	var errs []error
+
+	for _, source := range s.sources {
+		list, err := s.load(ctx, source)
+		if err != nil {
+			s.logger.Error("Failed to refresh reputation list", "source", source, "error", err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			continue
+		}
+
+		s.mu.Lock()
+		s.lists[source] = list
+		s.mu.Unlock()
+
+		s.logger.Info("Reputation list loaded", "source", source, "entries", list.Len())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) load(ctx context.Context, source string) (*List, error) {
+	if !isRemote(source) {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return Parse(source, data)
+	}
+
+	data, fetchErr := s.fetch(ctx, source)
+	if fetchErr == nil {
+		list, err := Parse(source, data)
+		if err != nil {
+			return nil, err
+		}
+		s.writeCache(source, data)
+		return list, nil
+	}
+
+	s.mu.RLock()
+	_, loaded := s.lists[source]
+	s.mu.RUnlock()
+	if loaded {
+		return nil, fetchErr
+	}
+
+	data, err := s.readCache(source)
+	if err != nil {
+		return nil, fetchErr
+	}
+	s.logger.Warn("Using cached reputation list", "source", source, "error", fetchErr.Error())
+
+	return Parse(source, data)
+}
+
+func (s *Store) fetch(ctx context.Context, source string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxListSize {
+		return nil, fmt.Errorf("list larger than %d bytes", maxListSize)
+	}
+
+	return data, nil
+}
+
+func (s *Store) cachePath(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(s.cacheDir, hex.EncodeToString(sum[:8])+".list")
+}
+
+func (s *Store) writeCache(source string, data []byte) {
+	if s.cacheDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0o755); err != nil {
+		s.logger.Warn("Failed to create reputation cache directory", "error", err.Error())
+		return
+	}
+
+	// Written aside and renamed, so a crash never leaves half a list.
+	path := s.cachePath(source)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		s.logger.Warn("Failed to cache reputation list", "source", source, "error", err.Error())
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		s.logger.Warn("Failed to cache reputation list", "source", source, "error", err.Error())
+	}
+}
+
+func (s *Store) readCache(source string) ([]byte, error) {
+	if s.cacheDir == "" {
+		return nil, os.ErrNotExist
+	}
+
+	return os.ReadFile(s.cachePath(source))
+}
+
+// Check looks rawUrl up in every loaded list.
+func (s *Store) Check(rawUrl string) (Match, bool) {
+	if s == nil {
+		return Match{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, source := range s.sources {
+		if list, ok := s.lists[source]; ok {
+			if match, ok := list.Check(rawUrl); ok {
+				return match, true
+			}
+		}
+	}
+
+	return Match{}, false
+}
+
+func isRemote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
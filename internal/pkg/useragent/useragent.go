@@ -0,0 +1,133 @@
+package useragent
+
+import "strings"
+
+const (
+	OSIOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSChromeOS = "chromeos"
+	OSLinux    = "linux"
+	OSOther    = "other"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "curl/", "wget/", "python-requests",
+	"go-http-client", "facebookexternalhit", "headless",
+}
+
+// unfurlMarkers identify the crawlers chat apps and social networks send
+// to build link previews.
+var unfurlMarkers = []string{
+	"facebookexternalhit", "facebookcatalog", "meta-externalagent", "twitterbot",
+	"slackbot", "slack-imgproxy", "discordbot", "telegrambot", "whatsapp",
+	"linkedinbot", "skypeuripreview", "pinterestbot", "redditbot", "embedly",
+	"iframely", "mastodon", "cardyb", "vkshare", "viber", "kakaotalk-scrap",
+	"line-poker", "snapchat", "google-pagerenderer",
+}
+
+// Parse classifies a User-Agent header with simple substring checks. It is
+// meant for analytics buckets and routing, not exact version detection.
+func Parse(ua string) Info {
+	lower := strings.ToLower(ua)
+
+	return Info{
+		Browser: parseBrowser(lower),
+		OS:      parseOS(lower),
+		Device:  parseDevice(lower),
+	}
+}
+
+func IsBot(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsUnfurler reports whether ua belongs to a link preview crawler.
+func IsUnfurler(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, marker := range unfurlMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case ua == "":
+		return "Unknown"
+	case IsBot(ua):
+		return "Bot"
+	case strings.Contains(ua, "edg/") || strings.Contains(ua, "edga/") || strings.Contains(ua, "edgios/"):
+		return "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		return "Opera"
+	case strings.Contains(ua, "samsungbrowser/"):
+		return "Samsung Internet"
+	case strings.Contains(ua, "firefox/") || strings.Contains(ua, "fxios/"):
+		return "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		return "Chrome"
+	case strings.Contains(ua, "safari/"):
+		return "Safari"
+	case strings.Contains(ua, "msie ") || strings.Contains(ua, "trident/"):
+		return "Internet Explorer"
+	default:
+		return "Other"
+	}
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return OSIOS
+	case strings.Contains(ua, "android"):
+		return OSAndroid
+	case strings.Contains(ua, "windows"):
+		return OSWindows
+	case strings.Contains(ua, "cros"):
+		return OSChromeOS
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		return OSMacOS
+	case strings.Contains(ua, "linux"):
+		return OSLinux
+	default:
+		return OSOther
+	}
+}
+
+func parseDevice(ua string) string {
+	switch {
+	case IsBot(ua):
+		return DeviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return DeviceTablet
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
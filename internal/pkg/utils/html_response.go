@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"path/filepath"
+)
+
+// HTMLResponse renders templates/<pageName> inside the shared layout. The
+// page is rendered into a buffer first so a template error never leaves a
+// half-written response behind.
+func HTMLResponse(w http.ResponseWriter, status int, pageName string, data any) error {
+	pagePath := filepath.Join("templates", pageName)
+	layoutPath := filepath.Join("templates", "layout.gohtml")
+
+	tmpl, err := template.ParseFiles(layoutPath, pagePath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
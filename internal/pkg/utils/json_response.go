@@ -59,9 +59,9 @@ func ParseJSON(w http.ResponseWriter, r *http.Request, dest any) error {
 				Message: "Request body must not be empty",
 			}
 		default:
+			slog.Debug("error json", "error", err)
 			return err
 		}
-		slog.Debug("error json", "error", err)
 	}
 
 	return nil
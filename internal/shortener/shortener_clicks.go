@@ -0,0 +1,266 @@
+package shortener
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+type Click struct {
+	ShortCode      string
+	ClickedAt      time.Time
+	Referrer       string
+	UserAgent      string
+	IPHash         string
+	AcceptLanguage string
+	Country        string
+	Source         string
+	Variant        string
+}
+
+// ClickSourceQR tags clicks that came from a scanned QR code, the codes
+// served by GetQRCode point at the short URL with ?src=qr.
+const ClickSourceQR = "qr"
+
+var clickSources = map[string]struct{}{
+	ClickSourceQR: {},
+}
+
+// ClickRecorder buffers clicks in memory and writes them to the database in
+// batches from a single background goroutine, so a redirect never waits on
+// the clicks table.
+type ClickRecorder struct {
+	cfg      *config.Config
+	logger   *slog.Logger
+	db       *sql.DB
+	visitors *Visitors
+
+	clicks chan Click
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewClickRecorder(cfg *config.Config, logger *slog.Logger, db *sql.DB, visitors *Visitors) *ClickRecorder {
+	bufferSize := max(cfg.CLICK_BUFFER_SIZE, 1)
+
+	c := &ClickRecorder{
+		cfg:      cfg,
+		logger:   logger,
+		db:       db,
+		visitors: visitors,
+		clicks:   make(chan Click, bufferSize),
+		done:     make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// RecordRequest captures the click described by r, which was sent to the
+// given A/B variant, if any. It never blocks, when the buffer is full the
+// click is dropped.
+func (c *ClickRecorder) RecordRequest(r *http.Request, code string, variant string) {
+	c.Record(Click{
+		ShortCode:      code,
+		ClickedAt:      time.Now(),
+		Referrer:       truncate(r.Referer(), 2048),
+		UserAgent:      truncate(r.UserAgent(), 512),
+		IPHash:         c.hashIP(clientIP(r, c.cfg.TRUST_PROXY_HEADERS)),
+		AcceptLanguage: truncate(r.Header.Get("Accept-Language"), 255),
+		Country:        c.visitors.Country(r),
+		Source:         clickSource(r),
+		Variant:        variant,
+	})
+}
+
+func (c *ClickRecorder) Record(click Click) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.clicks <- click:
+	default:
+		c.logger.Warn("Click buffer full, dropping click", "code", click.ShortCode)
+	}
+}
+
+// Close stops accepting clicks and waits until everything buffered has been
+// flushed, or until ctx is done.
+func (c *ClickRecorder) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.clicks)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *ClickRecorder) run() {
+	defer close(c.done)
+
+	batchSize := max(c.cfg.CLICK_BATCH_SIZE, 1)
+	interval := c.cfg.CLICK_FLUSH_INTERVAL
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]Click, 0, batchSize)
+	for {
+		select {
+		case click, ok := <-c.clicks:
+			if !ok {
+				c.flush(batch)
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= batchSize {
+				c.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				c.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (c *ClickRecorder) flush(batch []Click) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.logger.Error("Failed to begin click transaction", "error", err.Error(), "dropped", len(batch))
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(
+		ctx,
+		`INSERT INTO clicks (url_id, short_code, clicked_at, referrer, user_agent, ip_hash, accept_language, country, source, variant)
+		SELECT id, short_url, $2, $3, $4, $5, $6, $7, $8, $9 FROM urls WHERE short_url = $1`,
+	)
+	if err != nil {
+		c.logger.Error("Failed to prepare click insert", "error", err.Error(), "dropped", len(batch))
+		return
+	}
+	defer stmt.Close()
+
+	for _, click := range batch {
+		_, err := stmt.ExecContext(
+			ctx,
+			click.ShortCode,
+			click.ClickedAt,
+			click.Referrer,
+			click.UserAgent,
+			click.IPHash,
+			click.AcceptLanguage,
+			click.Country,
+			click.Source,
+			click.Variant,
+		)
+		if err != nil {
+			c.logger.Error("Failed to insert click", "error", err.Error(), "dropped", len(batch))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.logger.Error("Failed to commit clicks", "error", err.Error(), "dropped", len(batch))
+		return
+	}
+
+	c.logger.Debug("Clicks flushed", "count", len(batch))
+}
+
+func (c *ClickRecorder) hashIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.cfg.CLICK_IP_SALT))
+	mac.Write([]byte(ip))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// clickSource reads the ?src= marker, only known sources are kept so the
+// column can't be filled with arbitrary strings.
+func clickSource(r *http.Request) string {
+	source := r.URL.Query().Get("src")
+	if _, ok := clickSources[source]; !ok {
+		return ""
+	}
+
+	return source
+}
+
+// clientIP returns the address of the visitor. Forwarding headers are only
+// honoured when the server is known to sit behind a proxy.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// truncate cuts s to at most n bytes of valid UTF-8, header values are not
+// guaranteed to be valid and postgres rejects invalid text.
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\x00", "")
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,233 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+const base62Charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// CodeGenerator produces candidate short codes. A candidate is not
+// guaranteed to be free, the caller is expected to retry on conflict.
+type CodeGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Bounds of SHORT_CODE_LENGTH. Shorter codes run out quickly, and past 11
+// characters the offsets of the sequence generator overflow a uint64.
+const (
+	minCodeLength = 4
+	maxCodeLength = 11
+)
+
+func newCodeGenerator(cfg *config.Config, logger *slog.Logger, db *sql.DB) (CodeGenerator, error) {
+	length := cfg.SHORT_CODE_LENGTH
+	if length <= 0 {
+		length = 7
+	}
+	if length < minCodeLength || length > maxCodeLength {
+		return nil, fmt.Errorf("SHORT_CODE_LENGTH must be between %d and %d, got %d", minCodeLength, maxCodeLength, length)
+	}
+
+	switch cfg.SHORT_CODE_GENERATOR {
+	case "sequence":
+		return newSequenceCodeGenerator(db, length), nil
+	case "sqids":
+		alphabet := cfg.SHORT_CODE_ALPHABET
+		if alphabet != "" && !isValidSqidsAlphabet(alphabet) {
+			logger.Warn("Invalid short code alphabet, falling back to default", "alphabet", alphabet)
+			alphabet = ""
+		}
+		if alphabet == "" {
+			alphabet = base62Charset
+		}
+		return newSqidsCodeGenerator(db, alphabet, length), nil
+	case "", "random":
+		return newRandomCodeGenerator(length), nil
+	default:
+		logger.Warn("Unknown short code generator, falling back to random", "generator", cfg.SHORT_CODE_GENERATOR)
+		return newRandomCodeGenerator(length), nil
+	}
+}
+
+// randomCodeGenerator picks base62 characters from crypto/rand.
+type randomCodeGenerator struct {
+	length int
+	source io.Reader
+}
+
+func newRandomCodeGenerator(length int) *randomCodeGenerator {
+	return &randomCodeGenerator{length: length, source: rand.Reader}
+}
+
+func (g *randomCodeGenerator) Generate(_ context.Context) (string, error) {
+	// Largest multiple of len(base62Charset) that fits in a byte, anything
+	// above it is rejected so every character is equally likely.
+	const limit = 256 - 256%len(base62Charset)
+
+	code := make([]byte, 0, g.length)
+	buf := make([]byte, g.length*2)
+
+	for len(code) < g.length {
+		if _, err := io.ReadFull(g.source, buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, base62Charset[int(b)%len(base62Charset)])
+			if len(code) == g.length {
+				break
+			}
+		}
+	}
+
+	return string(code), nil
+}
+
+// sequenceCodeGenerator encodes the next value of urls_code_seq in base62.
+// Values are offset so that every code has at least the configured length.
+type sequenceCodeGenerator struct {
+	db     *sql.DB
+	offset uint64
+}
+
+func newSequenceCodeGenerator(db *sql.DB, length int) *sequenceCodeGenerator {
+	offset := uint64(1)
+	for range length - 1 {
+		offset *= uint64(len(base62Charset))
+	}
+
+	return &sequenceCodeGenerator{db: db, offset: offset}
+}
+
+func (g *sequenceCodeGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := nextCodeSequence(ctx, g.db)
+	if err != nil {
+		return "", err
+	}
+
+	return g.encode(id), nil
+}
+
+func (g *sequenceCodeGenerator) encode(id uint64) string {
+	return encodeBase62(id+g.offset, base62Charset)
+}
+
+// sqidsCodeGenerator turns the next value of urls_code_seq into a
+// Sqids-style id, so codes stay unique without exposing the row count.
+type sqidsCodeGenerator struct {
+	db        *sql.DB
+	alphabet  string
+	minLength int
+}
+
+func newSqidsCodeGenerator(db *sql.DB, alphabet string, minLength int) *sqidsCodeGenerator {
+	return &sqidsCodeGenerator{
+		db:        db,
+		alphabet:  string(sqidsShuffle([]byte(alphabet))),
+		minLength: minLength,
+	}
+}
+
+func (g *sqidsCodeGenerator) Generate(ctx context.Context) (string, error) {
+	id, err := nextCodeSequence(ctx, g.db)
+	if err != nil {
+		return "", err
+	}
+
+	return g.encode(id), nil
+}
+
+func (g *sqidsCodeGenerator) encode(id uint64) string {
+	return sqidsEncode(id, g.alphabet, g.minLength)
+}
+
+func nextCodeSequence(ctx context.Context, db *sql.DB) (uint64, error) {
+	var id int64
+	if err := db.QueryRowContext(ctx, "SELECT nextval('urls_code_seq')").Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return uint64(id), nil
+}
+
+func encodeBase62(n uint64, alphabet string) string {
+	if n == 0 {
+		return alphabet[:1]
+	}
+
+	base := uint64(len(alphabet))
+	var code []byte
+	for n > 0 {
+		code = append(code, alphabet[n%base])
+		n /= base
+	}
+	slices.Reverse(code)
+
+	return string(code)
+}
+
+func isValidSqidsAlphabet(alphabet string) bool {
+	if len(alphabet) < 3 {
+		return false
+	}
+
+	seen := make(map[rune]struct{}, len(alphabet))
+	for _, c := range alphabet {
+		if c > 127 || !aliasPattern.MatchString(string(c)) {
+			return false
+		}
+		if _, ok := seen[c]; ok {
+			return false
+		}
+		seen[c] = struct{}{}
+	}
+
+	return true
+}
+
+// sqidsEncode follows the Sqids encoding for a single number, without
+// the profanity blocklist.
+func sqidsEncode(n uint64, alphabet string, minLength int) string {
+	chars := []byte(alphabet)
+	size := len(chars)
+
+	offset := (int(chars[n%uint64(size)]) + 1) % size
+	chars = slices.Concat(chars[offset:], chars[:offset])
+
+	prefix := chars[0]
+	slices.Reverse(chars)
+
+	id := []byte{prefix}
+	id = append(id, encodeBase62(n, string(chars[1:]))...)
+
+	if len(id) < minLength {
+		id = append(id, chars[0])
+		for len(id) < minLength {
+			chars = sqidsShuffle(chars)
+			id = append(id, chars[:min(minLength-len(id), size)]...)
+		}
+	}
+
+	return string(id)
+}
+
+func sqidsShuffle(chars []byte) []byte {
+	shuffled := slices.Clone(chars)
+
+	for i, j := 0, len(shuffled)-1; j > 0; i, j = i+1, j-1 {
+		r := (i*j + int(shuffled[i]) + int(shuffled[j])) % len(shuffled)
+		shuffled[i], shuffled[r] = shuffled[r], shuffled[i]
+	}
+
+	return shuffled
+}
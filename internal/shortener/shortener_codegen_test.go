@@ -0,0 +1,193 @@
+package shortener
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+func TestRandomCodeGenerator(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		source []byte
+		want   string
+	}{
+		{
+			name:   "bytes map onto the charset",
+			length: 4,
+			source: []byte{0, 25, 26, 61, 0, 0, 0, 0},
+			want:   "azA9",
+		},
+		{
+			name:   "bytes past the last full cycle are rejected",
+			length: 4,
+			source: []byte{255, 248, 0, 62, 247, 1, 2, 3},
+			want:   "aa9b",
+		},
+		{
+			name:   "a fully rejected read is followed by another",
+			length: 2,
+			source: []byte{250, 251, 252, 253, 124, 63, 0, 0},
+			want:   "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &randomCodeGenerator{length: tt.length, source: bytes.NewReader(tt.source)}
+
+			got, err := g.Generate(context.Background())
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Generate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomCodeGeneratorUnique(t *testing.T) {
+	g := newRandomCodeGenerator(7)
+	seen := make(map[string]struct{})
+
+	for range 10000 {
+		code, err := g.Generate(context.Background())
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if len(code) != 7 || strings.Trim(code, base62Charset) != "" {
+			t.Fatalf("Generate = %q, want 7 base62 characters", code)
+		}
+		if _, ok := seen[code]; ok {
+			t.Fatalf("Generate returned %q twice", code)
+		}
+		seen[code] = struct{}{}
+	}
+}
+
+func TestSequenceCodeGenerator(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		id     uint64
+		want   string
+	}{
+		{"offset of length one", 1, 0, "b"},
+		{"first id of length two", 2, 0, "ba"},
+		{"first id of length three", 3, 0, "baa"},
+		{"last id of length three", 3, 62*62*61 - 1, "999"},
+		{"codes grow past the length", 3, 62 * 62 * 61, "baaa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSequenceCodeGenerator(nil, tt.length)
+
+			if got := g.encode(tt.id); got != tt.want {
+				t.Errorf("encode(%d) = %q, want %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSqidsCodeGenerator(t *testing.T) {
+	// Reference ids of the Sqids spec for the default alphabet.
+	g := newSqidsCodeGenerator(nil, base62Charset, 0)
+	for id, want := range []string{"bM", "Uk", "gb", "Ef", "Vq", "uw", "OI", "AX", "p6", "nJ"} {
+		if got := g.encode(uint64(id)); got != want {
+			t.Errorf("encode(%d) = %q, want %q", id, got, want)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		alphabet  string
+		minLength int
+	}{
+		{"default alphabet", base62Charset, 7},
+		{"short alphabet", "abc", 4},
+		{"min length above alphabet size", "0123456789", 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSqidsCodeGenerator(nil, tt.alphabet, tt.minLength)
+			seen := make(map[string]uint64)
+
+			for id := range uint64(5000) {
+				code := g.encode(id)
+				if len(code) < tt.minLength {
+					t.Fatalf("encode(%d) = %q, want at least %d characters", id, code, tt.minLength)
+				}
+				if strings.Trim(code, tt.alphabet) != "" {
+					t.Fatalf("encode(%d) = %q, want only characters of %q", id, code, tt.alphabet)
+				}
+				if other, ok := seen[code]; ok {
+					t.Fatalf("encode(%d) = encode(%d) = %q", id, other, code)
+				}
+				seen[code] = id
+			}
+		})
+	}
+}
+
+func TestIsValidSqidsAlphabet(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		want     bool
+	}{
+		{base62Charset, true},
+		{"abc", true},
+		{"ab", false},
+		{"abca", false},
+		{"ab/c", false},
+		{"abcé", false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidSqidsAlphabet(tt.alphabet); got != tt.want {
+			t.Errorf("isValidSqidsAlphabet(%q) = %v, want %v", tt.alphabet, got, tt.want)
+		}
+	}
+}
+
+func TestNewCodeGeneratorLength(t *testing.T) {
+	tests := []struct {
+		length int
+		ok     bool
+	}{
+		{0, true},
+		{-1, true},
+		{1, false},
+		{3, false},
+		{4, true},
+		{11, true},
+		{12, false},
+		{64, false},
+	}
+
+	for _, tt := range tests {
+		for _, generator := range []string{"random", "sequence", "sqids"} {
+			cfg := &config.Config{SHORT_CODE_GENERATOR: generator, SHORT_CODE_LENGTH: tt.length}
+			_, err := newCodeGenerator(cfg, testLogger, nil)
+			if (err == nil) != tt.ok {
+				t.Errorf("%s generator with length %d: error = %v, want ok: %v", generator, tt.length, err, tt.ok)
+			}
+		}
+	}
+}
+
+func TestSequenceCodeGeneratorMaxLength(t *testing.T) {
+	g := newSequenceCodeGenerator(nil, maxCodeLength)
+
+	for _, id := range []uint64{0, 1, 1 << 40, 1 << 62} {
+		if code := g.encode(id); len(code) != maxCodeLength {
+			t.Errorf("encode(%d) = %q, want %d characters", id, code, maxCodeLength)
+		}
+	}
+}
@@ -1,8 +1,1557 @@
 package shortener
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image/color"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/pkg/metadata"
+	"github.com/badiwidya/yaurl/internal/pkg/qrcode"
+	"github.com/badiwidya/yaurl/internal/pkg/types"
+	"github.com/badiwidya/yaurl/internal/pkg/useragent"
+)
 
 type URL struct {
-	Url     string     `json:"url"`
-	Expires *time.Time `json:"expires,omitempty"`
+	Url          string        `json:"url"`
+	Alias        string        `json:"alias,omitempty"`
+	Expires      *time.Time    `json:"expires,omitempty"`
+	ActiveFrom   *time.Time    `json:"active_from,omitempty"`
+	Password     string        `json:"password,omitempty"`
+	MaxClicks    *int          `json:"max_clicks,omitempty"`
+	Preview      bool          `json:"preview,omitempty"`
+	ForwardQuery string        `json:"forward_query,omitempty"`
+	ForwardPath  bool          `json:"forward_path,omitempty"`
+	Rules        RedirectRules `json:"rules,omitempty"`
+	Variants     Variants      `json:"variants,omitempty"`
+	// RedirectStatus is one of 301, 302, 307 or 308, 0 uses the instance
+	// default.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// FallbackUrl is used instead of Url while the health checker finds
+	// Url failing.
+	FallbackUrl string `json:"fallback_url,omitempty"`
+	// Social overrides what link previews in chat apps show.
+	Social *SocialCard `json:"social,omitempty"`
+}
+
+// UpdateURL carries the mutable attributes of a link, nil fields are left
+// untouched.
+type UpdateURL struct {
+	Url        *string    `json:"url,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	ActiveFrom *time.Time `json:"active_from,omitempty"`
+	// ClearActiveFrom removes the start time, so the link is live right
+	// away.
+	ClearActiveFrom bool           `json:"clear_active_from,omitempty"`
+	Preview         *bool          `json:"preview,omitempty"`
+	ForwardQuery    *string        `json:"forward_query,omitempty"`
+	ForwardPath     *bool          `json:"forward_path,omitempty"`
+	Rules           *RedirectRules `json:"rules,omitempty"`
+	Variants        *Variants      `json:"variants,omitempty"`
+	// RedirectStatus 0 switches the link back to the instance default.
+	RedirectStatus *int `json:"redirect_status,omitempty"`
+	// FallbackUrl "" removes the fallback.
+	FallbackUrl *string `json:"fallback_url,omitempty"`
+	// Social replaces the link preview overrides, an empty object removes
+	// them.
+	Social *SocialCard `json:"social,omitempty"`
+}
+
+func (u UpdateURL) Validate() error {
+	errs := make(types.ValidationErrors)
+
+	if u.Url == nil && u.Expires == nil && u.ActiveFrom == nil && !u.ClearActiveFrom &&
+		u.Preview == nil && u.ForwardQuery == nil && u.ForwardPath == nil && u.Rules == nil &&
+		u.Variants == nil && u.RedirectStatus == nil && u.FallbackUrl == nil &&
+		u.Social == nil {
+		errs["body"] = "at least one field must be set"
+	}
+
+	if u.ActiveFrom != nil && u.ClearActiveFrom {
+		errs["clear_active_from"] = "must not be set together with active_from"
+	}
+
+	if u.ForwardQuery != nil && !validForwardQuery(*u.ForwardQuery) {
+		errs["forward_query"] = "must be empty, destination or request"
+	}
+
+	if u.Url != nil && *u.Url == "" {
+		errs["url"] = "must not be empty"
+	}
+
+	if u.Rules != nil {
+		u.Rules.validate(errs)
+	}
+
+	if u.Variants != nil {
+		u.Variants.validate(errs)
+	}
+
+	if u.RedirectStatus != nil && *u.RedirectStatus != 0 && !validRedirectStatus(*u.RedirectStatus) {
+		errs["redirect_status"] = "must be 301, 302, 307 or 308"
+	}
+
+	if u.Social != nil {
+		u.Social.validate(errs)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// LinkSnapshot is the set of mutable attributes saved in url_history
+// before every change.
+type LinkSnapshot struct {
+	LongUrl        string        `json:"long_url"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	ActiveFrom     *time.Time    `json:"active_from,omitempty"`
+	Preview        bool          `json:"preview,omitempty"`
+	ForwardQuery   string        `json:"forward_query,omitempty"`
+	ForwardPath    bool          `json:"forward_path,omitempty"`
+	Rules          RedirectRules `json:"rules,omitempty"`
+	Variants       Variants      `json:"variants,omitempty"`
+	RedirectStatus *int          `json:"redirect_status,omitempty"`
+	FallbackUrl    *string       `json:"fallback_url,omitempty"`
+	Social         *SocialCard   `json:"social,omitempty"`
+}
+
+// ScheduleEntry switches the destination of a link to Url from StartsAt
+// on, until the next entry starts.
+type ScheduleEntry struct {
+	StartsAt time.Time `json:"starts_at"`
+	Url      string    `json:"url"`
+}
+
+const maxScheduleEntries = 100
+
+// UpdateSchedule replaces the whole destination schedule of a link, an
+// empty list clears it.
+type UpdateSchedule struct {
+	Entries []ScheduleEntry `json:"entries"`
+}
+
+func (u UpdateSchedule) Validate() error {
+	errs := make(types.ValidationErrors)
+
+	if u.Entries == nil {
+		errs["entries"] = "field required"
+	}
+
+	if len(u.Entries) > maxScheduleEntries {
+		errs["entries"] = "must not have more than 100 entries"
+	}
+
+	seen := make(map[time.Time]struct{}, len(u.Entries))
+	for i, entry := range u.Entries {
+		field := fmt.Sprintf("entries[%d]", i)
+
+		if entry.StartsAt.IsZero() {
+			errs[field+".starts_at"] = "field required"
+		} else {
+			startsAt := entry.StartsAt.UTC()
+			if _, ok := seen[startsAt]; ok {
+				errs[field+".starts_at"] = "must be unique"
+			}
+			seen[startsAt] = struct{}{}
+		}
+
+		if entry.Url == "" {
+			errs[field+".url"] = "field required"
+		} else if validateLongUrl(entry.Url) != nil {
+			errs[field+".url"] = "must be an absolute URL"
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+type HistoryEntry struct {
+	Id        int          `json:"id"`
+	ChangedBy *int         `json:"changed_by,omitempty"`
+	ChangedAt time.Time    `json:"changed_at"`
+	Previous  LinkSnapshot `json:"previous"`
+}
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedCodes holds the first path segments already claimed by
+// setupRouter, an alias must never shadow one of them.
+var reservedCodes = map[string]struct{}{
+	"web": {},
+	"api": {},
+}
+
+func (u URL) Validate() error {
+	errs := make(types.ValidationErrors)
+
+	if u.Url == "" {
+		errs["url"] = "field required"
+	}
+
+	if u.Alias != "" {
+		if msg := validateAlias(u.Alias); msg != "" {
+			errs["alias"] = msg
+		}
+	}
+
+	if u.Password != "" {
+		if msg := validateLinkPassword(u.Password); msg != "" {
+			errs["password"] = msg
+		}
+	}
+
+	if u.MaxClicks != nil && *u.MaxClicks < 1 {
+		errs["max_clicks"] = "must be at least 1"
+	}
+
+	if !validForwardQuery(u.ForwardQuery) {
+		errs["forward_query"] = "must be empty, destination or request"
+	}
+
+	if u.ActiveFrom != nil && u.Expires != nil && !u.ActiveFrom.Before(*u.Expires) {
+		errs["active_from"] = "must be before expires"
+	}
+
+	u.Rules.validate(errs)
+	u.Variants.validate(errs)
+
+	if u.RedirectStatus != 0 && !validRedirectStatus(u.RedirectStatus) {
+		errs["redirect_status"] = "must be 301, 302, 307 or 308"
+	}
+
+	if u.Social != nil {
+		u.Social.validate(errs)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateAlias(alias string) string {
+	switch {
+	case len(alias) < aliasMinLength:
+		return "must be at least 3 characters"
+	case len(alias) > aliasMaxLength:
+		return "must be at most 64 characters"
+	case !aliasPattern.MatchString(alias):
+		return "may only contain letters, numbers, '-' and '_'"
+	}
+
+	if _, ok := reservedCodes[strings.ToLower(alias)]; ok {
+		return "is reserved"
+	}
+
+	return ""
+}
+
+const (
+	linkPasswordMinLength = 4
+	linkPasswordMaxLength = 128
+)
+
+func validateLinkPassword(password string) string {
+	switch {
+	case len(password) < linkPasswordMinLength:
+		return "must be at least 4 characters"
+	case len(password) > linkPasswordMaxLength:
+		return "must be at most 128 characters"
+	}
+
+	return ""
+}
+
+const (
+	StatsIntervalHour = "hour"
+	StatsIntervalDay  = "day"
+	StatsIntervalWeek = "week"
+
+	statsDefaultRange = 30 * 24 * time.Hour
+	statsMaxBuckets   = 2000
+	statsTopLimit     = 10
+)
+
+type StatsQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+	Location *time.Location
+}
+
+// NewStatsQuery reads from, to, interval and tz from the query string. from
+// and to accept RFC 3339 timestamps or plain dates, a plain date for to
+// includes the whole day.
+func NewStatsQuery(values url.Values, now time.Time) (StatsQuery, error) {
+	errs := make(types.ValidationErrors)
+
+	query := StatsQuery{
+		Interval: StatsIntervalDay,
+		Location: time.UTC,
+	}
+
+	if tz := values.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			errs["tz"] = "unknown time zone"
+		} else {
+			query.Location = loc
+		}
+	}
+
+	switch interval := values.Get("interval"); interval {
+	case "":
+	case StatsIntervalHour, StatsIntervalDay, StatsIntervalWeek:
+		query.Interval = interval
+	default:
+		errs["interval"] = "must be one of hour, day or week"
+	}
+
+	query.To = now
+	if to := values.Get("to"); to != "" {
+		t, isDate, err := parseStatsTime(to, query.Location)
+		if err != nil {
+			errs["to"] = "must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		} else if isDate {
+			query.To = t.AddDate(0, 0, 1)
+		} else {
+			query.To = t
+		}
+	}
+
+	query.From = query.To.Add(-statsDefaultRange)
+	if from := values.Get("from"); from != "" {
+		t, _, err := parseStatsTime(from, query.Location)
+		if err != nil {
+			errs["from"] = "must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		} else {
+			query.From = t
+		}
+	}
+
+	if len(errs) == 0 {
+		if !query.From.Before(query.To) {
+			errs["from"] = "must be before to"
+		} else if len(statsBuckets(query)) > statsMaxBuckets {
+			errs["interval"] = "range contains too many buckets, use a wider interval"
+		}
+	}
+
+	if len(errs) > 0 {
+		return query, errs
+	}
+
+	return query, nil
+}
+
+func parseStatsTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, loc); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// statsBuckets returns the start of every bucket overlapping the query
+// range, in the query's time zone.
+func statsBuckets(q StatsQuery) []time.Time {
+	var buckets []time.Time
+
+	for t := truncateToInterval(q.From, q.Interval, q.Location); t.Before(q.To); {
+		buckets = append(buckets, t)
+
+		switch q.Interval {
+		case StatsIntervalHour:
+			next := truncateToInterval(t.Add(time.Hour), q.Interval, q.Location)
+			// A repeated wall-clock hour at the end of DST truncates back
+			// onto t, skip past it.
+			if !next.After(t) {
+				next = truncateToInterval(t.Add(2*time.Hour), q.Interval, q.Location)
+			}
+			t = next
+		case StatsIntervalWeek:
+			t = t.AddDate(0, 0, 7)
+		default:
+			t = t.AddDate(0, 0, 1)
+		}
+
+		if len(buckets) > statsMaxBuckets {
+			break
+		}
+	}
+
+	return buckets
+}
+
+// truncateToInterval mirrors postgres date_trunc on wall-clock time, weeks
+// start on Monday.
+func truncateToInterval(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+
+	switch interval {
+	case StatsIntervalHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case StatsIntervalWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+}
+
+type UrlStats struct {
+	Code         string        `json:"code"`
+	From         time.Time     `json:"from"`
+	To           time.Time     `json:"to"`
+	Interval     string        `json:"interval"`
+	TimeZone     string        `json:"time_zone"`
+	TotalClicks  int64         `json:"total_clicks"`
+	UniqueClicks int64         `json:"unique_clicks"`
+	Series       []StatsBucket `json:"series"`
+	TopReferrers []StatsCount  `json:"top_referrers"`
+	TopBrowsers  []StatsCount  `json:"top_browsers"`
+	TopCountries []StatsCount  `json:"top_countries"`
+	Sources      []StatsCount  `json:"sources"`
+	Variants     []StatsCount  `json:"variants"`
+}
+
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+type StatsCount struct {
+	Name   string `json:"name"`
+	Clicks int64  `json:"clicks"`
+}
+
+const (
+	ListSortCreated = "created"
+	ListSortExpires = "expires"
+	ListSortClicks  = "clicks"
+
+	ListStatusActive    = "active"
+	ListStatusExpired   = "expired"
+	ListStatusDisabled  = "disabled"
+	ListStatusTrash     = "trash"
+	ListStatusExhausted = "exhausted"
+	ListStatusScheduled = "scheduled"
+
+	HealthUnknown = "unknown"
+	HealthHealthy = "healthy"
+	HealthFailing = "failing"
+
+	listDefaultLimit = 20
+	listMaxLimit     = 100
+)
+
+type ListQuery struct {
+	Cursor    *ListCursor
+	Limit     int
+	Sort      string
+	Ascending bool
+	Status    string
+	Domain    string
+	Search    string
+	Health    string
+}
+
+// ListCursor points just past the last link of a page, Value holds the sort
+// key of that link and Id breaks ties between equal keys.
+type ListCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+func (c ListCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(value string) (*ListCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor ListCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+func NewListQuery(values url.Values) (ListQuery, error) {
+	errs := make(types.ValidationErrors)
+
+	query := ListQuery{
+		Limit:  listDefaultLimit,
+		Sort:   ListSortCreated,
+		Domain: strings.ToLower(strings.TrimSpace(values.Get("domain"))),
+		Search: strings.TrimSpace(values.Get("q")),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > listMaxLimit {
+			errs["limit"] = "must be a number between 1 and 100"
+		} else {
+			query.Limit = n
+		}
+	}
+
+	switch sort := values.Get("sort"); sort {
+	case "":
+	case ListSortCreated, ListSortExpires, ListSortClicks:
+		query.Sort = sort
+	default:
+		errs["sort"] = "must be one of created, expires or clicks"
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		errs["order"] = "must be asc or desc"
+	}
+
+	switch status := values.Get("status"); status {
+	case "", ListStatusActive, ListStatusScheduled, ListStatusExpired, ListStatusDisabled, ListStatusExhausted, ListStatusTrash:
+		query.Status = status
+	default:
+		errs["status"] = "must be one of active, scheduled, expired, disabled, exhausted or trash"
+	}
+
+	switch health := values.Get("health"); health {
+	case "", HealthUnknown, HealthHealthy, HealthFailing:
+		query.Health = health
+	default:
+		errs["health"] = "must be one of unknown, healthy or failing"
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := decodeListCursor(cursor)
+		if err != nil || !c.validFor(query.Sort) {
+			errs["cursor"] = "is invalid for this query"
+		} else {
+			query.Cursor = c
+		}
+	}
+
+	if len(errs) > 0 {
+		return query, errs
+	}
+
+	return query, nil
+}
+
+func (c ListCursor) validFor(sort string) bool {
+	if c.Sort != sort {
+		return false
+	}
+
+	switch sort {
+	case ListSortClicks:
+		_, err := strconv.ParseInt(c.Value, 10, 64)
+		return err == nil
+	default:
+		_, err := time.Parse(time.RFC3339Nano, c.Value)
+		return err == nil
+	}
+}
+
+type Link struct {
+	Code            string        `json:"code"`
+	ShortUrl        string        `json:"short_url"`
+	LongUrl         string        `json:"long_url"`
+	CreatedAt       time.Time     `json:"created_at"`
+	ExpiresAt       time.Time     `json:"expires_at"`
+	ActiveFrom      *time.Time    `json:"active_from,omitempty"`
+	DisabledAt      *time.Time    `json:"disabled_at,omitempty"`
+	DeletedAt       *time.Time    `json:"deleted_at,omitempty"`
+	Protected       bool          `json:"protected"`
+	Preview         bool          `json:"preview"`
+	ForwardQuery    string        `json:"forward_query"`
+	ForwardPath     bool          `json:"forward_path"`
+	Rules           RedirectRules `json:"rules"`
+	Variants        Variants      `json:"variants"`
+	RedirectStatus  *int          `json:"redirect_status,omitempty"`
+	FlaggedAt       *time.Time    `json:"flagged_at,omitempty"`
+	FlagReason      string        `json:"flag_reason,omitempty"`
+	FallbackUrl     *string       `json:"fallback_url,omitempty"`
+	Health          string        `json:"health"`
+	HealthCheckedAt *time.Time    `json:"health_checked_at,omitempty"`
+	// Metadata is read from the destination page, MetadataError tells why
+	// the latest attempt failed.
+	Metadata          *LinkMetadata `json:"metadata,omitempty"`
+	MetadataFetchedAt *time.Time    `json:"metadata_fetched_at,omitempty"`
+	MetadataError     string        `json:"metadata_error,omitempty"`
+	Social            *SocialCard   `json:"social,omitempty"`
+	MaxClicks         *int          `json:"max_clicks,omitempty"`
+	RemainingClicks   *int          `json:"remaining_clicks,omitempty"`
+	Clicks            int64         `json:"clicks"`
+}
+
+type LinkPage struct {
+	Links      []Link `json:"links"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+const (
+	BulkModeAtomic     = "atomic"
+	BulkModeBestEffort = "best_effort"
+)
+
+// BulkRow is one row of a bulk request. Rows of an uploaded CSV file carry
+// their line in the file and the problems found while reading them.
+type BulkRow struct {
+	URL
+	Line   int
+	Errors types.ValidationErrors
+}
+
+func bulkRows(urls []URL) []BulkRow {
+	rows := make([]BulkRow, len(urls))
+	for i, u := range urls {
+		rows[i] = BulkRow{URL: u}
+	}
+
+	return rows
+}
+
+type BulkResult struct {
+	Index    int                    `json:"index"`
+	Line     int                    `json:"line,omitempty"`
+	Url      string                 `json:"url"`
+	ShortUrl string                 `json:"short_url,omitempty"`
+	Errors   types.ValidationErrors `json:"errors,omitempty"`
+}
+
+type BulkReport struct {
+	Mode    string       `json:"mode"`
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"`
+}
+
+// parseBulkCSV reads url, alias and expires columns. A header row is
+// optional and, when present, may list the columns in any order. Blank
+// rows are skipped, and a cell that can't be read or a row with more
+// cells than there are columns fails only its own row.
+func parseBulkCSV(r io.Reader) ([]BulkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	columns := map[string]int{"url": 0, "alias": 1, "expires": 2}
+	width := len(columns)
+	isUrlColumn := func(name string) bool { return strings.EqualFold(strings.TrimSpace(name), "url") }
+	if len(records) > 0 && slices.ContainsFunc(records[0], isUrlColumn) {
+		columns = make(map[string]int)
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		width = len(records[0])
+		records, lines = records[1:], lines[1:]
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := make([]BulkRow, 0, len(records))
+	for i, record := range records {
+		if !slices.ContainsFunc(record, func(cell string) bool { return strings.TrimSpace(cell) != "" }) {
+			continue
+		}
+
+		row := BulkRow{
+			URL: URL{
+				Url:   field(record, "url"),
+				Alias: field(record, "alias"),
+			},
+			Line: lines[i],
+		}
+
+		if expires := field(record, "expires"); expires != "" {
+			t, _, err := parseStatsTime(expires, time.UTC)
+			if err != nil {
+				row.Errors = types.ValidationErrors{"expires": "must be an RFC 3339 timestamp or a YYYY-MM-DD date"}
+			} else {
+				row.Expires = &t
+			}
+		}
+
+		if len(record) > width {
+			if row.Errors == nil {
+				row.Errors = make(types.ValidationErrors)
+			}
+			row.Errors["row"] = fmt.Sprintf("has %d cells, want at most %d", len(record), width)
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("CSV contains no rows")
+	}
+
+	return rows, nil
+}
+
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+)
+
+type ExportedClick struct {
+	Code           string    `json:"code"`
+	ClickedAt      time.Time `json:"clicked_at"`
+	Referrer       string    `json:"referrer"`
+	UserAgent      string    `json:"user_agent"`
+	Country        string    `json:"country"`
+	AcceptLanguage string    `json:"accept_language"`
+	Source         string    `json:"source"`
+	Variant        string    `json:"variant"`
+}
+
+const (
+	ImportStatusImported = "imported"
+	ImportStatusConflict = "conflict"
+	ImportStatusInvalid  = "invalid"
+)
+
+type ImportResult struct {
+	Code   string `json:"code"`
+	Url    string `json:"url"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	Imported  int            `json:"imported"`
+	Conflicts int            `json:"conflicts"`
+	Invalid   int            `json:"invalid"`
+	Results   []ImportResult `json:"results"`
+}
+
+// validateImportedCode is looser than validateAlias, codes from other
+// shorteners may be shorter than our own minimum but must still be safe to
+// route and must not shadow our reserved paths.
+func validateImportedCode(code string) string {
+	switch {
+	case code == "":
+		return "missing short code"
+	case len(code) > 255:
+		return "short code is longer than 255 characters"
+	case !aliasPattern.MatchString(code):
+		return "short code may only contain letters, numbers, '-' and '_'"
+	}
+
+	if _, ok := reservedCodes[strings.ToLower(code)]; ok {
+		return "short code is reserved"
+	}
+
+	return ""
+}
+
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+
+	qrDefaultSize   = 256
+	qrMinSize       = 64
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4
+	qrMaxMargin     = 32
+)
+
+var qrLevels = map[string]qrcode.ECCLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.Quartile,
+	"H": qrcode.High,
+}
+
+type QRQuery struct {
+	Format string
+	Level  qrcode.ECCLevel
+	qrcode.RenderOptions
+}
+
+// NewQRQuery reads format, size, margin, ecc, fg and bg from the query
+// string. Colours are hex RGB values with or without a leading '#'.
+func NewQRQuery(values url.Values) (QRQuery, error) {
+	errs := make(types.ValidationErrors)
+
+	query := QRQuery{
+		Format: QRFormatPNG,
+		Level:  qrcode.Medium,
+		RenderOptions: qrcode.RenderOptions{
+			Size:       qrDefaultSize,
+			Margin:     qrDefaultMargin,
+			Foreground: color.RGBA{A: 0xff},
+			Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		},
+	}
+
+	switch format := strings.ToLower(values.Get("format")); format {
+	case "":
+	case QRFormatPNG, QRFormatSVG:
+		query.Format = format
+	default:
+		errs["format"] = "must be png or svg"
+	}
+
+	if size := values.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			errs["size"] = "must be a number between 64 and 2048"
+		} else {
+			query.Size = n
+		}
+	}
+
+	if margin := values.Get("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil || n < 0 || n > qrMaxMargin {
+			errs["margin"] = "must be a number between 0 and 32"
+		} else {
+			query.Margin = n
+		}
+	}
+
+	if ecc := values.Get("ecc"); ecc != "" {
+		level, ok := qrLevels[strings.ToUpper(ecc)]
+		if !ok {
+			errs["ecc"] = "must be one of L, M, Q or H"
+		} else {
+			query.Level = level
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *color.RGBA
+	}{
+		{"fg", &query.Foreground},
+		{"bg", &query.Background},
+	} {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		c, ok := parseHexColor(value)
+		if !ok {
+			errs[param.name] = "must be a hex colour like 000 or 1a2b3c"
+			continue
+		}
+		*param.dest = c
+	}
+
+	if len(errs) > 0 {
+		return QRQuery{}, errs
+	}
+
+	return query, nil
+}
+
+func parseHexColor(value string) (color.RGBA, bool) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) != 6 {
+		return color.RGBA{}, false
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, true
+}
+
+// LinkPreview is what the interstitial page tells a visitor about a link
+// before they follow it.
+type LinkPreview struct {
+	Code      string
+	ShortUrl  string
+	LongUrl   string
+	Domain    string
+	OwnerName string
+	CreatedAt time.Time
+}
+
+// FlaggedLink is a link the reputation check disabled, as listed for
+// admins.
+type FlaggedLink struct {
+	Code      string    `json:"code"`
+	ShortUrl  string    `json:"short_url"`
+	LongUrl   string    `json:"long_url"`
+	Owner     string    `json:"owner"`
+	FlaggedAt time.Time `json:"flagged_at"`
+	Reason    string    `json:"reason"`
+}
+
+// LinkMetadata is the title, description, Open Graph tags and favicon of
+// the destination page of a link.
+type LinkMetadata struct {
+	metadata.Metadata
+}
+
+func (m LinkMetadata) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+func (m *LinkMetadata) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, m)
+	case string:
+		return json.Unmarshal([]byte(src), m)
+	}
+
+	return fmt.Errorf("cannot scan %T into LinkMetadata", src)
+}
+
+// SocialCard is what the owner of a link wants link previews to show in
+// place of the destination's own title, description and image.
+type SocialCard struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+const (
+	socialTitleMaxLength       = 300
+	socialDescriptionMaxLength = 1000
+	socialImageMaxLength       = 2048
+)
+
+func (c SocialCard) empty() bool {
+	return c == SocialCard{}
+}
+
+func (c SocialCard) validate(errs types.ValidationErrors) {
+	if len(c.Title) > socialTitleMaxLength {
+		errs["social.title"] = "must be at most 300 characters"
+	}
+
+	if len(c.Description) > socialDescriptionMaxLength {
+		errs["social.description"] = "must be at most 1000 characters"
+	}
+
+	if c.Image != "" {
+		parsed, err := url.Parse(c.Image)
+		switch {
+		case len(c.Image) > socialImageMaxLength:
+			errs["social.image"] = "must be at most 2048 characters"
+		case err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "":
+			errs["social.image"] = "must be an absolute http or https URL"
+		}
+	}
+}
+
+func (c SocialCard) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *SocialCard) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, c)
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	}
+
+	return fmt.Errorf("cannot scan %T into SocialCard", src)
+}
+
+// LinkCard is the page link preview crawlers get instead of the redirect.
+// It leaves out the destination, anyone can claim to be a crawler.
+type LinkCard struct {
+	ShortUrl    string
+	Title       string
+	Description string
+	Image       string
+	// Tags are the Open Graph properties of the page, in order.
+	Tags []CardTag
+}
+
+type CardTag struct {
+	Property string
+	Content  string
+}
+
+// LinkHealth is the state the health checker keeps for a link, with its
+// latest checks first.
+type LinkHealth struct {
+	Status      string        `json:"status"`
+	Url         *string       `json:"url,omitempty"`
+	CheckedAt   *time.Time    `json:"checked_at,omitempty"`
+	Failures    int           `json:"failures"`
+	FallbackUrl *string       `json:"fallback_url,omitempty"`
+	History     []HealthCheck `json:"history"`
+}
+
+type HealthCheck struct {
+	CheckedAt  time.Time `json:"checked_at"`
+	Url        string    `json:"url"`
+	Healthy    bool      `json:"healthy"`
+	StatusCode *int      `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int       `json:"duration_ms"`
+}
+
+// Kinds of link events.
+const (
+	EventLinkFailing   = "link_failing"
+	EventLinkRecovered = "link_recovered"
+)
+
+// LinkEvent tells an owner something happened to one of their links.
+type LinkEvent struct {
+	Id        int64     `json:"id"`
+	Code      string    `json:"code"`
+	Kind      string    `json:"kind"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PreviewDomain forces the interstitial on every link whose destination is
+// the domain or one of its subdomains.
+type PreviewDomain struct {
+	Domain    string    `json:"domain"`
+	CreatedBy *int      `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type NewPreviewDomain struct {
+	Domain string `json:"domain"`
+}
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func (d NewPreviewDomain) Validate() error {
+	errs := make(types.ValidationErrors)
+
+	if d.Domain == "" {
+		errs["domain"] = "field required"
+	} else if msg := validateDomain(d.Domain); msg != "" {
+		errs["domain"] = msg
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// normalizeDomain lower-cases domain and drops a trailing dot, the form
+// domains are stored and compared in.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+func validateDomain(domain string) string {
+	domain = normalizeDomain(domain)
+	if len(domain) > 253 || !domainPattern.MatchString(domain) {
+		return "must be a domain name like example.com"
+	}
+
+	return ""
+}
+
+// Query forwarding modes. Parameters of the visit are appended to the
+// destination's own, they only differ when both set the same parameter:
+// with ForwardQueryDestination the destination's values are kept and the
+// visit's dropped, with ForwardQueryRequest every destination value of that
+// parameter is replaced by the visit's. Markers that belong to the
+// shortener itself, like src and confirm, are never forwarded.
+const (
+	ForwardQueryOff         = ""
+	ForwardQueryDestination = "destination"
+	ForwardQueryRequest     = "request"
+)
+
+func validForwardQuery(mode string) bool {
+	switch mode {
+	case ForwardQueryOff, ForwardQueryDestination, ForwardQueryRequest:
+		return true
+	}
+
+	return false
+}
+
+// internalParams are query parameters read by the redirect handler.
+var internalParams = map[string]struct{}{
+	"src":     {},
+	"confirm": {},
+}
+
+// Destination is where a visit to a link leads, along with the link's
+// passthrough settings.
+type Destination struct {
+	LongUrl      string
+	ForwardQuery string
+	ForwardPath  bool
+	Rules        RedirectRules
+	Variants     Variants
+	// Variant is the name of the variant Route picked, if any.
+	Variant string
+	// Status is the HTTP status the visit is redirected with, and
+	// CacheMaxAge how long a permanent one may be cached.
+	Status      int
+	CacheMaxAge time.Duration
+	// Fallback is set when LongUrl is the fallback of a failing link.
+	Fallback bool
+}
+
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// CacheControl is the Cache-Control header for the redirect. Permanent
+// redirects may be cached for CacheMaxAge, privately when the target
+// depends on who visits. Temporary ones, and fallbacks, are never reused,
+// so every visit is counted and follows the latest destination.
+func (d Destination) CacheControl() string {
+	if d.Fallback || (d.Status != http.StatusMovedPermanently && d.Status != http.StatusPermanentRedirect) {
+		return "private, no-cache"
+	}
+
+	scope := "public"
+	if len(d.Rules) > 0 || len(d.Variants) > 0 {
+		scope = "private"
+	}
+
+	return fmt.Sprintf("%s, max-age=%d", scope, int(d.CacheMaxAge.Seconds()))
+}
+
+// Route returns the destination for v. The first rule v matches wins,
+// otherwise a link with variants sends v to one of them, and only then to
+// its own long URL.
+func (d Destination) Route(v Visitor) Destination {
+	for _, rule := range d.Rules {
+		if rule.Matches(v) {
+			d.LongUrl = rule.Url
+			return d
+		}
+	}
+
+	if variant, ok := d.Variants.pick(v); ok {
+		d.LongUrl = variant.Url
+		d.Variant = variant.Name
+	}
+
+	return d
+}
+
+// Resolve builds the final redirect target. rest is the still escaped path
+// after the code and rawQuery the query string of the visit, either is
+// ignored unless the link opted into forwarding it.
+func (d Destination) Resolve(rest string, rawQuery string) string {
+	forwardPath := d.ForwardPath && rest != ""
+	forwardQuery := d.ForwardQuery != ForwardQueryOff && rawQuery != ""
+	if !forwardPath && !forwardQuery {
+		return d.LongUrl
+	}
+
+	target, err := url.Parse(d.LongUrl)
+	if err != nil {
+		return d.LongUrl
+	}
+
+	if forwardPath {
+		if unescaped, err := url.PathUnescape(rest); err == nil {
+			// Cleaned on its own first, so dot segments can't climb above
+			// the destination's path.
+			cleaned := path.Clean("/" + unescaped)
+			if strings.HasSuffix(unescaped, "/") && cleaned != "/" {
+				cleaned += "/"
+			}
+			target = target.JoinPath(cleaned)
+		}
+	}
+
+	if forwardQuery {
+		target.RawQuery = mergeQuery(target.RawQuery, rawQuery, d.ForwardQuery == ForwardQueryRequest)
+	}
+
+	return target.String()
+}
+
+// mergeQuery appends the parameters of incoming to those of base, working
+// on the raw pairs so that both sides keep their order and encoding.
+func mergeQuery(base, incoming string, incomingWins bool) string {
+	baseKeys := make(map[string]struct{})
+	for _, pair := range splitQuery(base) {
+		baseKeys[queryKey(pair)] = struct{}{}
+	}
+
+	var added []string
+	addedKeys := make(map[string]struct{})
+	for _, pair := range splitQuery(incoming) {
+		key := queryKey(pair)
+		if _, ok := internalParams[key]; ok {
+			continue
+		}
+		if _, ok := baseKeys[key]; ok && !incomingWins {
+			continue
+		}
+		added = append(added, pair)
+		addedKeys[key] = struct{}{}
+	}
+
+	merged := make([]string, 0, len(baseKeys)+len(added))
+	for _, pair := range splitQuery(base) {
+		if _, ok := addedKeys[queryKey(pair)]; ok {
+			continue
+		}
+		merged = append(merged, pair)
+	}
+
+	return strings.Join(append(merged, added...), "&")
+}
+
+func splitQuery(rawQuery string) []string {
+	var pairs []string
+	for pair := range strings.SplitSeq(rawQuery, "&") {
+		if pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+func queryKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+
+	return key
+}
+
+// Visitor is what redirect rules can match a visit on. Every field is
+// lower case except Country, empty when unknown.
+type Visitor struct {
+	OS       string
+	Device   string
+	Country  string
+	Language string
+	Referrer string
+	// Key identifies the visitor across visits and Variant is the variant
+	// they were given before, both keep A/B assignments sticky.
+	Key     string
+	Variant string
+}
+
+// RedirectRule sends visitors matching every condition it sets to Url. A
+// condition matches when any of its values does: OS and Device take the
+// useragent names, Country ISO 3166 codes, Language tags such as en, which
+// also covers en-US, and Referrer domains, which also cover subdomains.
+type RedirectRule struct {
+	OS       []string `json:"os,omitempty"`
+	Device   []string `json:"device,omitempty"`
+	Country  []string `json:"country,omitempty"`
+	Language []string `json:"language,omitempty"`
+	Referrer []string `json:"referrer,omitempty"`
+	Url      string   `json:"url"`
+}
+
+func (r RedirectRule) Matches(v Visitor) bool {
+	return matchAny(r.OS, v.OS, strings.EqualFold) &&
+		matchAny(r.Device, v.Device, strings.EqualFold) &&
+		matchAny(r.Country, v.Country, strings.EqualFold) &&
+		matchAny(r.Language, v.Language, languageMatches) &&
+		matchAny(r.Referrer, v.Referrer, referrerMatches)
+}
+
+func matchAny(values []string, got string, match func(want, got string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	if got == "" {
+		return false
+	}
+
+	for _, want := range values {
+		if match(want, got) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func languageMatches(want, got string) bool {
+	want = strings.ToLower(want)
+
+	return got == want || strings.HasPrefix(got, want+"-")
+}
+
+func referrerMatches(want, host string) bool {
+	want = normalizeDomain(want)
+
+	return host == want || strings.HasSuffix(host, "."+want)
+}
+
+// RedirectRules are evaluated in order, the first match wins. They are
+// stored as JSON in urls.rules.
+type RedirectRules []RedirectRule
+
+func (r RedirectRules) Value() (driver.Value, error) {
+	if r == nil {
+		r = RedirectRules{}
+	}
+
+	return json.Marshal(r)
+}
+
+func (r *RedirectRules) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, r)
+	case string:
+		return json.Unmarshal([]byte(src), r)
+	}
+
+	return fmt.Errorf("cannot scan %T into RedirectRules", src)
+}
+
+const maxRedirectRules = 50
+
+var (
+	ruleOSes = []string{
+		useragent.OSIOS, useragent.OSAndroid, useragent.OSWindows, useragent.OSMacOS,
+		useragent.OSChromeOS, useragent.OSLinux, useragent.OSOther,
+	}
+	ruleDevices = []string{
+		useragent.DeviceMobile, useragent.DeviceTablet, useragent.DeviceDesktop, useragent.DeviceBot,
+	}
+	countryPattern  = regexp.MustCompile(`^[A-Za-z]{2}$`)
+	languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+)
+
+func (r RedirectRules) validate(errs types.ValidationErrors) {
+	if len(r) > maxRedirectRules {
+		errs["rules"] = "must not have more than 50 rules"
+	}
+
+	for i, rule := range r {
+		field := fmt.Sprintf("rules[%d]", i)
+
+		if len(rule.OS) == 0 && len(rule.Device) == 0 && len(rule.Country) == 0 &&
+			len(rule.Language) == 0 && len(rule.Referrer) == 0 {
+			errs[field] = "must set at least one condition"
+		}
+
+		for _, os := range rule.OS {
+			if !slices.Contains(ruleOSes, strings.ToLower(os)) {
+				errs[field+".os"] = "must be one of " + strings.Join(ruleOSes, ", ")
+			}
+		}
+
+		for _, device := range rule.Device {
+			if !slices.Contains(ruleDevices, strings.ToLower(device)) {
+				errs[field+".device"] = "must be one of " + strings.Join(ruleDevices, ", ")
+			}
+		}
+
+		for _, country := range rule.Country {
+			if !countryPattern.MatchString(country) {
+				errs[field+".country"] = "must be two letter ISO 3166 codes"
+			}
+		}
+
+		for _, language := range rule.Language {
+			if !languagePattern.MatchString(language) {
+				errs[field+".language"] = "must be language tags like en or pt-BR"
+			}
+		}
+
+		for _, referrer := range rule.Referrer {
+			if msg := validateDomain(referrer); msg != "" {
+				errs[field+".referrer"] = msg
+			}
+		}
+
+		if rule.Url == "" {
+			errs[field+".url"] = "field required"
+		} else if validateLongUrl(rule.Url) != nil {
+			errs[field+".url"] = "must be an absolute URL"
+		}
+	}
+}
+
+// Variant is one of the destinations a link splits its traffic between,
+// receiving Weight out of the sum of all weights.
+type Variant struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Variants are stored as JSON in urls.variants.
+type Variants []Variant
+
+func (vs Variants) Value() (driver.Value, error) {
+	if vs == nil {
+		vs = Variants{}
+	}
+
+	return json.Marshal(vs)
+}
+
+func (vs *Variants) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*vs = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, vs)
+	case string:
+		return json.Unmarshal([]byte(src), vs)
+	}
+
+	return fmt.Errorf("cannot scan %T into Variants", src)
+}
+
+// pick keeps a visitor on the variant they got before while it still takes
+// traffic. Others are placed by a hash of their key, so the same visitor
+// lands on the same variant even without the cookie.
+func (vs Variants) pick(v Visitor) (Variant, bool) {
+	total := 0
+	for _, variant := range vs {
+		if variant.Weight > 0 && variant.Name == v.Variant {
+			return variant, true
+		}
+		total += max(variant.Weight, 0)
+	}
+
+	if total <= 0 {
+		return Variant{}, false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(v.Key))
+	for _, variant := range vs {
+		h.Write([]byte{0})
+		h.Write([]byte(variant.Name))
+	}
+
+	point := int(h.Sum64() % uint64(total))
+	for _, variant := range vs {
+		if point < variant.Weight {
+			return variant, true
+		}
+		point -= max(variant.Weight, 0)
+	}
+
+	return Variant{}, false
+}
+
+const (
+	maxVariants      = 20
+	maxVariantWeight = 10000
+)
+
+var variantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+func (vs Variants) validate(errs types.ValidationErrors) {
+	if len(vs) > maxVariants {
+		errs["variants"] = "must not have more than 20 variants"
+	}
+
+	total := 0
+	names := make(map[string]struct{}, len(vs))
+	for i, variant := range vs {
+		field := fmt.Sprintf("variants[%d]", i)
+
+		if !variantNamePattern.MatchString(variant.Name) {
+			errs[field+".name"] = "must be 1 to 32 letters, numbers, '-' or '_'"
+		} else if _, ok := names[variant.Name]; ok {
+			errs[field+".name"] = "must be unique"
+		}
+		names[variant.Name] = struct{}{}
+
+		if variant.Url == "" {
+			errs[field+".url"] = "field required"
+		} else if validateLongUrl(variant.Url) != nil {
+			errs[field+".url"] = "must be an absolute URL"
+		}
+
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			errs[field+".weight"] = "must be between 0 and 10000"
+		}
+		total += max(variant.Weight, 0)
+	}
+
+	if len(vs) > 0 && total == 0 {
+		errs["variants"] = "at least one variant must have a weight above 0"
+	}
 }
@@ -0,0 +1,717 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/pkg/types"
+)
+
+func TestDestinationResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		dest     Destination
+		rest     string
+		rawQuery string
+		want     string
+	}{
+		{
+			name:     "nothing forwarded by default",
+			dest:     Destination{LongUrl: "https://example.com/docs?a=1"},
+			rest:     "guide",
+			rawQuery: "b=2",
+			want:     "https://example.com/docs?a=1",
+		},
+		{
+			name: "destination returned untouched without a visit path or query",
+			dest: Destination{LongUrl: "https://example.com/Docs?a=%41#Top", ForwardPath: true, ForwardQuery: ForwardQueryRequest},
+			want: "https://example.com/Docs?a=%41#Top",
+		},
+		{
+			name: "path appended",
+			dest: Destination{LongUrl: "https://example.com/docs", ForwardPath: true},
+			rest: "guide/intro",
+			want: "https://example.com/docs/guide/intro",
+		},
+		{
+			name: "path appended to a trailing slash",
+			dest: Destination{LongUrl: "https://example.com/docs/", ForwardPath: true},
+			rest: "guide/",
+			want: "https://example.com/docs/guide/",
+		},
+		{
+			name: "dot segments can't climb above the destination",
+			dest: Destination{LongUrl: "https://example.com/docs", ForwardPath: true},
+			rest: "../../admin",
+			want: "https://example.com/docs/admin",
+		},
+		{
+			name: "escaped dot segments are cleaned too",
+			dest: Destination{LongUrl: "https://example.com/docs", ForwardPath: true},
+			rest: "%2e%2e/%2E%2E/admin",
+			want: "https://example.com/docs/admin",
+		},
+		{
+			name: "dot segments inside the path",
+			dest: Destination{LongUrl: "https://example.com/docs", ForwardPath: true},
+			rest: "a/./b/../c/",
+			want: "https://example.com/docs/a/c/",
+		},
+		{
+			name: "only dot segments",
+			dest: Destination{LongUrl: "https://example.com/docs", ForwardPath: true},
+			rest: "../",
+			want: "https://example.com/docs/",
+		},
+		{
+			name: "escapes kept",
+			dest: Destination{LongUrl: "https://example.com/files", ForwardPath: true},
+			rest: "a%20b/c%3Fd",
+			want: "https://example.com/files/a%20b/c%3Fd",
+		},
+		{
+			name: "malformed escapes drop the path",
+			dest: Destination{LongUrl: "https://example.com/files", ForwardPath: true},
+			rest: "a%zz",
+			want: "https://example.com/files",
+		},
+		{
+			name:     "query appended",
+			dest:     Destination{LongUrl: "https://example.com/?a=1", ForwardQuery: ForwardQueryDestination},
+			rawQuery: "b=2&c=3",
+			want:     "https://example.com/?a=1&b=2&c=3",
+		},
+		{
+			name:     "destination wins a collision",
+			dest:     Destination{LongUrl: "https://example.com/?a=1&b=1", ForwardQuery: ForwardQueryDestination},
+			rawQuery: "b=2&c=3",
+			want:     "https://example.com/?a=1&b=1&c=3",
+		},
+		{
+			name:     "visit wins a collision",
+			dest:     Destination{LongUrl: "https://example.com/?a=1&b=1&b=2", ForwardQuery: ForwardQueryRequest},
+			rawQuery: "b=3&c=3&b=4",
+			want:     "https://example.com/?a=1&b=3&c=3&b=4",
+		},
+		{
+			name:     "keys compared unescaped",
+			dest:     Destination{LongUrl: "https://example.com/?utm%5Fsource=a", ForwardQuery: ForwardQueryDestination},
+			rawQuery: "utm_source=b&x+y=1",
+			want:     "https://example.com/?utm%5Fsource=a&x+y=1",
+		},
+		{
+			name:     "internal params dropped",
+			dest:     Destination{LongUrl: "https://example.com/?src=site", ForwardQuery: ForwardQueryRequest},
+			rawQuery: "src=qr&confirm=1&%63onfirm=1&ref=mail",
+			want:     "https://example.com/?src=site&ref=mail",
+		},
+		{
+			name:     "empty pairs dropped",
+			dest:     Destination{LongUrl: "https://example.com/", ForwardQuery: ForwardQueryDestination},
+			rawQuery: "&&a=1&&",
+			want:     "https://example.com/?a=1",
+		},
+		{
+			name:     "only internal params",
+			dest:     Destination{LongUrl: "https://example.com/page", ForwardQuery: ForwardQueryDestination},
+			rawQuery: "src=qr",
+			want:     "https://example.com/page",
+		},
+		{
+			name:     "fragment kept after path and query",
+			dest:     Destination{LongUrl: "https://example.com/docs?a=1#install", ForwardPath: true, ForwardQuery: ForwardQueryDestination},
+			rest:     "v2",
+			rawQuery: "b=2",
+			want:     "https://example.com/docs/v2?a=1&b=2#install",
+		},
+		{
+			name:     "visit can't set the fragment",
+			dest:     Destination{LongUrl: "https://example.com/docs", ForwardPath: true, ForwardQuery: ForwardQueryDestination},
+			rest:     "a%23b",
+			rawQuery: "q=%23x",
+			want:     "https://example.com/docs/a%23b?q=%23x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dest.Resolve(tt.rest, tt.rawQuery); got != tt.want {
+				t.Errorf("Resolve(%q, %q) = %q, want %q", tt.rest, tt.rawQuery, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationRouteRules(t *testing.T) {
+	dest := Destination{
+		LongUrl: "https://example.com/",
+		Rules: RedirectRules{
+			{OS: []string{"ios"}, Country: []string{"de"}, Url: "https://example.com/ios-de"},
+			{OS: []string{"iOS", "android"}, Url: "https://example.com/mobile-app"},
+			{Language: []string{"pt"}, Url: "https://example.com/pt"},
+			{Language: []string{"pt-br"}, Url: "https://example.com/pt-br"},
+			{Device: []string{"tablet"}, Referrer: []string{"News.Example."}, Url: "https://example.com/news-tablet"},
+			{Country: []string{"US", "CA"}, Url: "https://example.com/na"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{"no match", Visitor{OS: "windows", Country: "FR"}, "https://example.com/"},
+		{"unknown visitor", Visitor{}, "https://example.com/"},
+		{"every condition of a rule", Visitor{OS: "ios", Country: "DE"}, "https://example.com/ios-de"},
+		{"earlier rule wins", Visitor{OS: "ios", Country: "DE", Language: "pt"}, "https://example.com/ios-de"},
+		{"partial match falls through", Visitor{OS: "ios", Country: "FR"}, "https://example.com/mobile-app"},
+		{"any value of a condition", Visitor{OS: "android"}, "https://example.com/mobile-app"},
+		{"language covers regions", Visitor{Language: "pt-br"}, "https://example.com/pt"},
+		{"language prefix needs a dash", Visitor{Language: "ptx"}, "https://example.com/"},
+		{"referrer subdomain", Visitor{Device: "tablet", Referrer: "m.news.example"}, "https://example.com/news-tablet"},
+		{"referrer suffix needs a dot", Visitor{Device: "tablet", Referrer: "fakenews.example"}, "https://example.com/"},
+		{"missing condition value", Visitor{Referrer: "news.example"}, "https://example.com/"},
+		{"country case", Visitor{Country: "CA"}, "https://example.com/na"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dest.Route(tt.visitor).LongUrl; got != tt.want {
+				t.Errorf("Route(%+v) = %q, want %q", tt.visitor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationRouteRulesBeforeVariants(t *testing.T) {
+	dest := Destination{
+		LongUrl:  "https://example.com/",
+		Rules:    RedirectRules{{Country: []string{"ID"}, Url: "https://example.com/id"}},
+		Variants: Variants{{Name: "a", Url: "https://example.com/a", Weight: 1}},
+	}
+
+	routed := dest.Route(Visitor{Country: "ID", Key: "k"})
+	if routed.LongUrl != "https://example.com/id" || routed.Variant != "" {
+		t.Errorf("Route = %q, variant %q, want the rule's URL and no variant", routed.LongUrl, routed.Variant)
+	}
+
+	routed = dest.Route(Visitor{Country: "SG", Key: "k"})
+	if routed.LongUrl != "https://example.com/a" || routed.Variant != "a" {
+		t.Errorf("Route = %q, variant %q, want variant a", routed.LongUrl, routed.Variant)
+	}
+}
+
+func TestVariantsPickSticky(t *testing.T) {
+	vs := Variants{
+		{Name: "a", Url: "https://example.com/a", Weight: 1},
+		{Name: "b", Url: "https://example.com/b", Weight: 1},
+		{Name: "c", Url: "https://example.com/c", Weight: 1},
+	}
+
+	seen := make(map[string]bool)
+	for i := range 200 {
+		key := fmt.Sprintf("203.0.113.%d\x00Mozilla/5.0", i)
+
+		first, ok := vs.pick(Visitor{Key: key})
+		if !ok {
+			t.Fatalf("pick(%q) found no variant", key)
+		}
+		for range 3 {
+			if again, _ := vs.pick(Visitor{Key: key}); again != first {
+				t.Fatalf("pick(%q) = %s, then %s", key, first.Name, again.Name)
+			}
+		}
+		seen[first.Name] = true
+	}
+
+	if len(seen) != len(vs) {
+		t.Errorf("200 visitors only got variants %v", seen)
+	}
+}
+
+func TestVariantsPickWeights(t *testing.T) {
+	vs := Variants{
+		{Name: "control", Url: "https://example.com/a", Weight: 70},
+		{Name: "paused", Url: "https://example.com/b", Weight: 0},
+		{Name: "test", Url: "https://example.com/c", Weight: 20},
+		{Name: "holdout", Url: "https://example.com/d", Weight: 10},
+	}
+
+	const visitors = 20000
+	counts := make(map[string]int)
+	for i := range visitors {
+		variant, ok := vs.pick(Visitor{Key: fmt.Sprintf("visitor-%d", i)})
+		if !ok {
+			t.Fatal("pick found no variant")
+		}
+		counts[variant.Name]++
+	}
+
+	for _, variant := range vs {
+		share := float64(counts[variant.Name]) / visitors * 100
+		if math.Abs(share-float64(variant.Weight)) > 2 {
+			t.Errorf("%s got %.1f%% of visitors, want about %d%%", variant.Name, share, variant.Weight)
+		}
+	}
+	if counts["paused"] != 0 {
+		t.Errorf("paused got %d visitors, want none", counts["paused"])
+	}
+}
+
+func TestVariantsPickCookie(t *testing.T) {
+	vs := Variants{
+		{Name: "a", Url: "https://example.com/a", Weight: 1},
+		{Name: "b", Url: "https://example.com/b", Weight: 1},
+		{Name: "off", Url: "https://example.com/off", Weight: 0},
+	}
+	const key = "198.51.100.7\x00curl/8.0"
+	hashed, _ := vs.pick(Visitor{Key: key})
+	other := "a"
+	if hashed.Name == "a" {
+		other = "b"
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"no cookie", "", hashed.Name},
+		{"cookie keeps the variant", other, other},
+		{"stale cookie", "removed", hashed.Name},
+		{"cookie of a paused variant", "off", hashed.Name},
+		{"garbage cookie", "\x00'; DROP", hashed.Name},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variant, ok := vs.pick(Visitor{Key: key, Variant: tt.cookie})
+			if !ok || variant.Name != tt.want {
+				t.Errorf("pick = %q, %v, want %q", variant.Name, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariantsPickNone(t *testing.T) {
+	tests := []struct {
+		name string
+		vs   Variants
+	}{
+		{"no variants", nil},
+		{"no weight", Variants{{Name: "a", Url: "https://example.com/a"}}},
+		{"negative weight", Variants{{Name: "a", Url: "https://example.com/a", Weight: -5}}},
+	}
+
+	for _, tt := range tests {
+		if variant, ok := tt.vs.pick(Visitor{Key: "k", Variant: "a"}); ok {
+			t.Errorf("%s: pick = %q, want none", tt.name, variant.Name)
+		}
+	}
+
+	vs := Variants{{Name: "a", Url: "https://example.com/a", Weight: -5}, {Name: "b", Url: "https://example.com/b", Weight: 5}}
+	for i := range 50 {
+		if variant, ok := vs.pick(Visitor{Key: fmt.Sprint(i)}); !ok || variant.Name != "b" {
+			t.Fatalf("pick = %q, %v, want b", variant.Name, ok)
+		}
+	}
+}
+
+func TestParseBulkCSV(t *testing.T) {
+	type row struct {
+		line    int
+		url     string
+		alias   string
+		expires string
+		errors  types.ValidationErrors
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []row
+	}{
+		{
+			name:  "without a header",
+			input: "https://example.com/a\nhttps://example.com/b,promo\nhttps://example.com/c,,2030-01-02\n",
+			want: []row{
+				{line: 1, url: "https://example.com/a"},
+				{line: 2, url: "https://example.com/b", alias: "promo"},
+				{line: 3, url: "https://example.com/c", expires: "2030-01-02T00:00:00Z"},
+			},
+		},
+		{
+			name:  "header in another order",
+			input: "Expires, URL ,alias\n2030-01-02T03:04:05+07:00,https://example.com/a,a1\n,https://example.com/b,\n",
+			want: []row{
+				{line: 2, url: "https://example.com/a", alias: "a1", expires: "2030-01-01T20:04:05Z"},
+				{line: 3, url: "https://example.com/b"},
+			},
+		},
+		{
+			name:  "blank lines keep line numbers",
+			input: "url,alias\n\nhttps://example.com/a,a1\n\n\n , \nhttps://example.com/b,b1\n",
+			want: []row{
+				{line: 3, url: "https://example.com/a", alias: "a1"},
+				{line: 7, url: "https://example.com/b", alias: "b1"},
+			},
+		},
+		{
+			name:  "quoted cell over several lines",
+			input: "\"https://example.com/a\",\"multi\nline\"\nhttps://example.com/b\n",
+			want: []row{
+				{line: 1, url: "https://example.com/a", alias: "multi\nline"},
+				{line: 3, url: "https://example.com/b"},
+			},
+		},
+		{
+			name:  "bad URLs are left to validation",
+			input: "url\nnot a url\nftp://example.com/file\n",
+			want: []row{
+				{line: 2, url: "not a url"},
+				{line: 3, url: "ftp://example.com/file"},
+			},
+		},
+		{
+			name:  "bad expires",
+			input: "https://example.com/a,,tomorrow\nhttps://example.com/b\n",
+			want: []row{
+				{line: 1, url: "https://example.com/a", errors: types.ValidationErrors{"expires": "must be an RFC 3339 timestamp or a YYYY-MM-DD date"}},
+				{line: 2, url: "https://example.com/b"},
+			},
+		},
+		{
+			name:  "too many columns",
+			input: "https://example.com/a,a1,2030-01-02,extra\nhttps://example.com/b,b1,soon,extra\n",
+			want: []row{
+				{line: 1, url: "https://example.com/a", alias: "a1", expires: "2030-01-02T00:00:00Z", errors: types.ValidationErrors{"row": "has 4 cells, want at most 3"}},
+				{line: 2, url: "https://example.com/b", alias: "b1", errors: types.ValidationErrors{
+					"expires": "must be an RFC 3339 timestamp or a YYYY-MM-DD date",
+					"row":     "has 4 cells, want at most 3",
+				}},
+			},
+		},
+		{
+			name:  "more cells than the header",
+			input: "url,alias\nhttps://example.com/a,a1\nhttps://example.com/b,b1,2030-01-02\n",
+			want: []row{
+				{line: 2, url: "https://example.com/a", alias: "a1"},
+				{line: 3, url: "https://example.com/b", alias: "b1", errors: types.ValidationErrors{"row": "has 3 cells, want at most 2"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseBulkCSV(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("parseBulkCSV: %v", err)
+			}
+
+			got := make([]row, len(rows))
+			for i, r := range rows {
+				got[i] = row{line: r.Line, url: r.Url, alias: r.Alias, errors: r.Errors}
+				if r.Expires != nil {
+					got[i].expires = r.Expires.UTC().Format(time.RFC3339)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBulkCSVInvalid(t *testing.T) {
+	for _, input := range []string{"", "\n\n", "url,alias\n", "url\n , \n", "https://example.com/\"a\n"} {
+		if rows, err := parseBulkCSV(strings.NewReader(input)); err == nil {
+			t.Errorf("parseBulkCSV(%q) = %d rows, want an error", input, len(rows))
+		}
+	}
+}
+
+func TestCreateBulkShortUrlsRowErrors(t *testing.T) {
+	rows, err := parseBulkCSV(strings.NewReader("url,alias\nhttps://example.com/a,first\n\nnot a url,second\n,third\nhttps://example.com/d,no!\n"))
+	if err != nil {
+		t.Fatalf("parseBulkCSV: %v", err)
+	}
+
+	// Atomic mode stops before touching the database when a row is bad.
+	s := &service{
+		cfg:    &config.Config{},
+		logger: testLogger,
+		policy: newTestPolicy(t, ""),
+	}
+	report, err := s.CreateBulkShortUrls(context.Background(), rows, 1, BulkModeAtomic)
+	if err != nil {
+		t.Fatalf("CreateBulkShortUrls: %v", err)
+	}
+
+	want := []BulkResult{
+		{Index: 0, Line: 2, Url: "https://example.com/a"},
+		{Index: 1, Line: 4, Url: "not a url", Errors: types.ValidationErrors{"url": policyInvalid}},
+		{Index: 2, Line: 5, Errors: types.ValidationErrors{"url": "field required"}},
+		{Index: 3, Line: 6, Url: "https://example.com/d", Errors: types.ValidationErrors{"alias": "may only contain letters, numbers, '-' and '_'"}},
+	}
+	if report.Created != 0 || report.Failed != 3 || !reflect.DeepEqual(report.Results, want) {
+		t.Errorf("report = %+v, want 0 created, 3 failed and results %+v", report, want)
+	}
+}
+
+func TestNewStatsQuery(t *testing.T) {
+	now := time.Date(2025, 6, 15, 13, 45, 0, 0, time.UTC)
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		from     time.Time
+		to       time.Time
+		interval string
+		location *time.Location
+	}{
+		{
+			name:     "default window",
+			from:     now.Add(-30 * 24 * time.Hour),
+			to:       now,
+			interval: StatsIntervalDay,
+			location: time.UTC,
+		},
+		{
+			name:     "default window before to",
+			query:    "to=2025-03-01T00:00:00Z&interval=week",
+			from:     time.Date(2025, 1, 30, 0, 0, 0, 0, time.UTC),
+			to:       time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			interval: StatsIntervalWeek,
+			location: time.UTC,
+		},
+		{
+			name:     "a date for to includes the day",
+			query:    "from=2025-06-01&to=2025-06-01&interval=hour",
+			from:     time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			to:       time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+			interval: StatsIntervalHour,
+			location: time.UTC,
+		},
+		{
+			name:     "dates in the time zone",
+			query:    "from=2025-06-01&to=2025-06-07&tz=Asia/Jakarta",
+			from:     time.Date(2025, 6, 1, 0, 0, 0, 0, jakarta),
+			to:       time.Date(2025, 6, 8, 0, 0, 0, 0, jakarta),
+			interval: StatsIntervalDay,
+			location: jakarta,
+		},
+		{
+			name:     "timestamps keep their offset",
+			query:    "from=2025-06-01T10:00:00%2B07:00&to=2025-06-02T10:00:00Z&tz=Asia/Jakarta",
+			from:     time.Date(2025, 6, 1, 3, 0, 0, 0, time.UTC),
+			to:       time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC),
+			interval: StatsIntervalDay,
+			location: jakarta,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			q, err := NewStatsQuery(values, now)
+			if err != nil {
+				t.Fatalf("NewStatsQuery: %v", err)
+			}
+			if !q.From.Equal(tt.from) || !q.To.Equal(tt.to) || q.Interval != tt.interval || q.Location.String() != tt.location.String() {
+				t.Errorf("query = %v to %v by %s in %s, want %v to %v by %s in %s",
+					q.From, q.To, q.Interval, q.Location, tt.from, tt.to, tt.interval, tt.location)
+			}
+		})
+	}
+}
+
+func TestNewStatsQueryInvalid(t *testing.T) {
+	now := time.Date(2025, 6, 15, 13, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		query  string
+		fields []string
+	}{
+		{"tz=Mars/Olympus", []string{"tz"}},
+		{"tz=Local", []string{"tz"}},
+		{"interval=month", []string{"interval"}},
+		{"to=yesterday", []string{"to"}},
+		{"from=2025-13-01", []string{"from"}},
+		{"from=2025-06-01T00:00:00", []string{"from"}},
+		{"from=2025-06-02&to=2025-06-01", []string{"from"}},
+		{"from=2025-06-01T00:00:00Z&to=2025-06-01T00:00:00Z", []string{"from"}},
+		{"from=2025-06-20", []string{"from"}},
+		{"tz=Nowhere&interval=minute&to=x", []string{"tz", "interval", "to"}},
+		// 2001 hourly buckets, one more than allowed.
+		{"from=2025-01-01T00:00:00Z&to=2025-03-25T08:00:01Z&interval=hour", []string{"interval"}},
+		{"from=2000-01-01&to=2025-01-01", []string{"interval"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			_, err := NewStatsQuery(values, now)
+
+			var errs types.ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error = %v, want validation errors", err)
+			}
+			var fields []string
+			for field := range errs {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+			want := slices.Sorted(slices.Values(tt.fields))
+			if !slices.Equal(fields, want) {
+				t.Errorf("errors on %v, want %v", errs, want)
+			}
+		})
+	}
+}
+
+func TestNewStatsQueryMaxBuckets(t *testing.T) {
+	now := time.Date(2025, 6, 15, 13, 45, 0, 0, time.UTC)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	values := url.Values{
+		"from":     {from.Format(time.RFC3339)},
+		"to":       {from.Add(statsMaxBuckets * time.Hour).Format(time.RFC3339)},
+		"interval": {StatsIntervalHour},
+	}
+	q, err := NewStatsQuery(values, now)
+	if err != nil {
+		t.Fatalf("NewStatsQuery with %d buckets: %v", statsMaxBuckets, err)
+	}
+	if got := len(statsBuckets(q)); got != statsMaxBuckets {
+		t.Errorf("buckets = %d, want %d", got, statsMaxBuckets)
+	}
+
+	values.Set("to", from.Add(statsMaxBuckets*time.Hour+time.Second).Format(time.RFC3339))
+	if _, err := NewStatsQuery(values, now); err == nil {
+		t.Errorf("NewStatsQuery accepted %d buckets", statsMaxBuckets+1)
+	}
+}
+
+func TestStatsBuckets(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+
+	tests := []struct {
+		name string
+		q    StatsQuery
+		want []string
+	}{
+		{
+			name: "one bucket",
+			q:    StatsQuery{From: utc("2025-06-01T10:00:00Z"), To: utc("2025-06-01T10:00:01Z"), Interval: StatsIntervalHour, Location: time.UTC},
+			want: []string{"2025-06-01 10:00"},
+		},
+		{
+			name: "partial first and last day",
+			q:    StatsQuery{From: utc("2025-06-01T12:00:00Z"), To: utc("2025-06-03T00:00:01Z"), Interval: StatsIntervalDay, Location: time.UTC},
+			want: []string{"2025-06-01 00:00", "2025-06-02 00:00", "2025-06-03 00:00"},
+		},
+		{
+			name: "to on a boundary is exclusive",
+			q:    StatsQuery{From: utc("2025-06-01T00:00:00Z"), To: utc("2025-06-03T00:00:00Z"), Interval: StatsIntervalDay, Location: time.UTC},
+			want: []string{"2025-06-01 00:00", "2025-06-02 00:00"},
+		},
+		{
+			name: "weeks start on monday",
+			q:    StatsQuery{From: utc("2025-01-01T00:00:00Z"), To: utc("2025-01-13T00:00:00Z"), Interval: StatsIntervalWeek, Location: time.UTC},
+			want: []string{"2024-12-30 00:00", "2025-01-06 00:00"},
+		},
+		{
+			name: "days in the query's time zone",
+			q:    StatsQuery{From: utc("2025-06-01T00:00:00Z"), To: utc("2025-06-02T00:00:00Z"), Interval: StatsIntervalDay, Location: berlin},
+			want: []string{"2025-06-01 00:00", "2025-06-02 00:00"},
+		},
+		{
+			name: "days across the start of DST",
+			q:    StatsQuery{From: utc("2025-03-29T23:00:00Z"), To: utc("2025-03-31T22:00:00Z"), Interval: StatsIntervalDay, Location: berlin},
+			want: []string{"2025-03-30 00:00", "2025-03-31 00:00"},
+		},
+		{
+			name: "hours across the start of DST",
+			q:    StatsQuery{From: utc("2025-03-30T00:00:00Z"), To: utc("2025-03-30T02:00:00Z"), Interval: StatsIntervalHour, Location: berlin},
+			want: []string{"2025-03-30 01:00", "2025-03-30 03:00"},
+		},
+		{
+			name: "hours across the end of DST",
+			q:    StatsQuery{From: utc("2025-10-25T23:00:00Z"), To: utc("2025-10-26T02:00:00Z"), Interval: StatsIntervalHour, Location: berlin},
+			// The repeated hour is a single wall-clock bucket, as with
+			// date_trunc on the local time.
+			want: []string{"2025-10-26 01:00", "2025-10-26 02:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, bucket := range statsBuckets(tt.q) {
+				// Clicks are matched to buckets by their wall-clock start.
+				got = append(got, bucket.Format("2006-01-02 15:04"))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("buckets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateURLClearActiveFrom(t *testing.T) {
+	tests := []struct {
+		body   string
+		clear  bool
+		fields []string
+	}{
+		{`{"clear_active_from": true}`, true, nil},
+		{`{"clear_active_from": false}`, false, []string{"body"}},
+		{`{"active_from": null}`, false, []string{"body"}},
+		{`{"active_from": "2030-01-01T00:00:00Z", "clear_active_from": true}`, true, []string{"clear_active_from"}},
+		{`{"active_from": "2030-01-01T00:00:00Z"}`, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			var update UpdateURL
+			if err := json.Unmarshal([]byte(tt.body), &update); err != nil {
+				t.Fatal(err)
+			}
+			if update.ClearActiveFrom != tt.clear {
+				t.Errorf("ClearActiveFrom = %v, want %v", update.ClearActiveFrom, tt.clear)
+			}
+
+			var fields []string
+			var errs types.ValidationErrors
+			if err := update.Validate(); errors.As(err, &errs) {
+				for field := range errs {
+					fields = append(fields, field)
+				}
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
@@ -1,28 +1,72 @@
 package shortener
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/badiwidya/yaurl/internal/pkg/middlewares"
+	"github.com/badiwidya/yaurl/internal/pkg/qrcode"
+	"github.com/badiwidya/yaurl/internal/pkg/ratelimit"
+	"github.com/badiwidya/yaurl/internal/pkg/types"
 	"github.com/badiwidya/yaurl/internal/pkg/utils"
 )
 
-func NewHandler(service Service) *handler {
+func NewHandler(service Service, logger *slog.Logger, clicks *ClickRecorder, visitors *Visitors, unlockLimiter *ratelimit.Limiter) *handler {
 	return &handler{
-		service: service,
+		service:       service,
+		logger:        logger,
+		clicks:        clicks,
+		visitors:      visitors,
+		unlockLimiter: unlockLimiter,
 	}
 }
 
 type Handler interface {
 	ShortenURL(w http.ResponseWriter, r *http.Request)
 	RedirectUrl(w http.ResponseWriter, r *http.Request)
+	UnlockUrl(w http.ResponseWriter, r *http.Request)
+	GetQRCode(w http.ResponseWriter, r *http.Request)
+	GetUrlStats(w http.ResponseWriter, r *http.Request)
+	ListUrls(w http.ResponseWriter, r *http.Request)
+	UpdateUrl(w http.ResponseWriter, r *http.Request)
+	GetUrlHistory(w http.ResponseWriter, r *http.Request)
+	GetUrlHealth(w http.ResponseWriter, r *http.Request)
+	RefreshUrlMetadata(w http.ResponseWriter, r *http.Request)
+	ListUrlEvents(w http.ResponseWriter, r *http.Request)
+	RollbackUrl(w http.ResponseWriter, r *http.Request)
+	GetUrlSchedule(w http.ResponseWriter, r *http.Request)
+	UpdateUrlSchedule(w http.ResponseWriter, r *http.Request)
+	DisableUrl(w http.ResponseWriter, r *http.Request)
+	EnableUrl(w http.ResponseWriter, r *http.Request)
+	DeleteUrl(w http.ResponseWriter, r *http.Request)
+	RestoreUrl(w http.ResponseWriter, r *http.Request)
+	BulkShortenURLs(w http.ResponseWriter, r *http.Request)
+	ExportUrls(w http.ResponseWriter, r *http.Request)
+	ImportUrls(w http.ResponseWriter, r *http.Request)
+	ListPreviewDomains(w http.ResponseWriter, r *http.Request)
+	AddPreviewDomain(w http.ResponseWriter, r *http.Request)
+	RemovePreviewDomain(w http.ResponseWriter, r *http.Request)
+	ReloadDestinationPolicy(w http.ResponseWriter, r *http.Request)
+	ListFlaggedUrls(w http.ResponseWriter, r *http.Request)
+	ClearUrlFlag(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	service Service
+	service       Service
+	logger        *slog.Logger
+	clicks        *ClickRecorder
+	visitors      *Visitors
+	unlockLimiter *ratelimit.Limiter
 }
 
 func (h *handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
@@ -56,11 +100,35 @@ func (h *handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newURL, err := h.service.CreateNewShortUrl(ctx, longUrl.Url, userId, longUrl.Expires)
+	if err := longUrl.Validate(); err != nil {
+		var validationErrs types.ValidationErrors
+
+		if errors.As(err, &validationErrs) {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	newURL, err := h.service.CreateNewShortUrl(ctx, longUrl, userId)
 	if err != nil {
-		if err == ErrNotValidUrl {
+		var validationErrs types.ValidationErrors
+		if errors.As(err, &validationErrs) {
 			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
-				Message: "Invalid URL",
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return
+		}
+		if err == ErrAliasTaken {
+			utils.JSONResponse(w, http.StatusConflict, &utils.Response{
+				Message: "Alias already taken",
 			})
 			return
 		}
@@ -78,17 +146,1227 @@ func (h *handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// previewSuffix appended to a code asks for the interstitial instead of
+// the redirect, e.g. /abc123+.
+const previewSuffix = "+"
+
+// confirmParam marks a visit coming from the interstitial's continue
+// button.
+const confirmParam = "confirm"
+
 func (h *handler) RedirectUrl(w http.ResponseWriter, r *http.Request) {
 	code := r.PathValue("code")
 
 	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
 	defer close()
 
-	long_url, err := h.service.FindLongUrl(ctx, code)
+	if previewCode, ok := strings.CutSuffix(code, previewSuffix); ok {
+		h.renderPreview(ctx, w, r, previewCode, "")
+		return
+	}
+
+	if h.visitors.Unfurler(r) {
+		h.renderCard(ctx, w, code)
+		return
+	}
+
+	rest := restPath(r, code)
+
+	destination, err := h.service.FindLongUrl(ctx, code, r.URL.Query().Has(confirmParam))
+	if err != nil {
+		switch err {
+		case ErrPasswordRequired:
+			renderUnlock(w, http.StatusOK, "")
+		case ErrPreviewRequired:
+			h.renderPreview(ctx, w, r, code, rest)
+		default:
+			writeRedirectError(w, code, err)
+		}
+		return
+	}
+
+	routed := h.route(w, r, code, destination)
+	h.clicks.RecordRequest(r, code, routed.Variant)
+
+	w.Header().Set("Cache-Control", routed.CacheControl())
+	http.Redirect(w, r, routed.Resolve(rest, r.URL.RawQuery), routed.Status)
+}
+
+// variantCookie remembers the A/B variant a visitor got, scoped to the
+// link's path so every link has its own.
+const (
+	variantCookie       = "variant"
+	variantCookieMaxAge = 30 * 24 * 60 * 60
+)
+
+// route picks the destination for the visitor behind r and keeps them on
+// the same variant for later visits.
+func (h *handler) route(w http.ResponseWriter, r *http.Request, code string, destination *Destination) Destination {
+	visitor := h.visitors.Describe(r)
+	if cookie, err := r.Cookie(variantCookie); err == nil {
+		visitor.Variant = cookie.Value
+	}
+
+	routed := destination.Route(visitor)
+	if routed.Variant != "" && routed.Variant != visitor.Variant {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookie,
+			Value:    routed.Variant,
+			Path:     "/" + code,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return routed
+}
+
+// restPath returns the still escaped part of the path after the code, set
+// when the visit matched /{code}/{rest...}.
+func restPath(r *http.Request, code string) string {
+	if r.PathValue("rest") == "" {
+		return ""
+	}
+
+	rest, _ := strings.CutPrefix(r.URL.EscapedPath(), "/"+code+"/")
+
+	return rest
+}
+
+// unlockMaxBodySize is generous for a form carrying a single password.
+const unlockMaxBodySize = 4 << 10
+
+// UnlockUrl handles the password form rendered by RedirectUrl. Failed
+// attempts count against a per-link limit, successful ones don't.
+func (h *handler) UnlockUrl(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+
+	r.Body = http.MaxBytesReader(w, r.Body, unlockMaxBodySize)
+	if err := r.ParseForm(); err != nil {
+		renderUnlock(w, http.StatusBadRequest, "Invalid form submission.")
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	// Only links that really take a password are limited, anything else
+	// would let every made up code claim a counter.
+	protected, err := h.service.IsProtected(ctx, code)
+	if err != nil {
+		writeRedirectError(w, code, err)
+		return
+	}
+
+	if protected {
+		allowed, retryAfter := h.unlockLimiter.Take(code)
+		if !allowed {
+			seconds := int(retryAfter.Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+			renderUnlock(w, http.StatusTooManyRequests, "Too many failed attempts, please try again later.")
+			return
+		}
+	}
+
+	destination, err := h.service.UnlockUrl(ctx, code, r.PostFormValue("password"))
+	if err != nil {
+		if err == ErrWrongPassword {
+			renderUnlock(w, http.StatusUnauthorized, "Wrong password.")
+			return
+		}
+		h.unlockLimiter.Refund(code)
+		writeRedirectError(w, code, err)
+		return
+	}
+	h.unlockLimiter.Refund(code)
+
+	routed := h.route(w, r, code, destination)
+	h.clicks.RecordRequest(r, code, routed.Variant)
+
+	// Always 303 whatever the link's status, so the browser follows it
+	// with a GET instead of resubmitting the password.
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, routed.Resolve(restPath(r, code), r.URL.RawQuery), http.StatusSeeOther)
+}
+
+// GetQRCode renders a QR code pointing at the short URL, tagged with
+// ?src=qr so scans can be told apart from other clicks.
+func (h *handler) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	query, err := NewQRQuery(r.URL.Query())
+	if err != nil {
+		var validationErrs types.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	shortUrl, err := h.service.FindShortUrl(ctx, r.PathValue("code"))
+	if err != nil {
+		if err == ErrNotFound {
+			utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+				Message: "Short URL not found",
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	code, err := qrcode.Encode([]byte(*shortUrl+"?src="+ClickSourceQR), query.Level)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	if query.Format == QRFormatSVG {
+		contentType = "image/svg+xml"
+		err = code.WriteSVG(&buf, query.RenderOptions)
+	} else {
+		err = code.WritePNG(&buf, query.RenderOptions)
+	}
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// renderPreview shows the interstitial for code. The continue button keeps
+// the original query string, so markers like ?src=qr survive it.
+func (h *handler) renderPreview(ctx context.Context, w http.ResponseWriter, r *http.Request, code, rest string) {
+	preview, err := h.service.PreviewUrl(ctx, code, h.visitors.Describe(r))
+	if err != nil {
+		if err == ErrPasswordRequired {
+			renderUnlock(w, http.StatusOK, "")
+			return
+		}
+		writeRedirectError(w, code, err)
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set(confirmParam, "1")
+
+	continuePath := "/" + url.PathEscape(code)
+	if rest != "" {
+		continuePath += "/" + rest
+	}
+
+	data := struct {
+		*LinkPreview
+		ContinueUrl string
+	}{
+		LinkPreview: preview,
+		ContinueUrl: continuePath + "?" + query.Encode(),
+	}
+
+	if err := utils.HTMLResponse(w, http.StatusOK, "preview.gohtml", data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// renderCard answers link preview crawlers with the Open Graph tags of
+// code instead of redirecting them. Crawlers don't count as clicks.
+func (h *handler) renderCard(ctx context.Context, w http.ResponseWriter, code string) {
+	card, err := h.service.UnfurlUrl(ctx, code)
+	if err != nil {
+		writeRedirectError(w, code, err)
+		return
+	}
+
+	// The same URL redirects browsers, caches must tell them apart.
+	w.Header().Set("Vary", "User-Agent")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := utils.HTMLResponse(w, http.StatusOK, "unfurl.gohtml", card); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// writeRedirectError answers a visitor whose short link could not be
+// followed.
+func writeRedirectError(w http.ResponseWriter, code string, err error) {
+	switch err {
+	case ErrExpired:
+		renderUnavailable(w, http.StatusGone, "Link expired", "This link has expired and no longer points anywhere.")
+		return
+	case ErrDisabled:
+		renderUnavailable(w, http.StatusGone, "Link disabled", "This link has been disabled by its owner.")
+		return
+	case ErrNotActive:
+		renderUnavailable(w, http.StatusNotFound, "Link not active yet", "This link is not active yet, please check back later.")
+		return
+	case ErrExhausted:
+		renderUnavailable(w, http.StatusGone, "Link exhausted", "This link has reached its click limit and no longer points anywhere.")
+		return
+	case ErrFlagged:
+		renderWarning(w, code)
+		return
+	case ErrNotFound:
+		renderUnavailable(w, http.StatusNotFound, "Link not found", "There is no link at this address, please check it for typos.")
+		return
+	}
+	renderUnavailable(w, http.StatusInternalServerError, "Something went wrong", "We couldn't follow this link right now, please try again later.")
+}
+
+func (h *handler) GetUrlStats(w http.ResponseWriter, r *http.Request) {
+	contextValue := r.Context().Value(middlewares.UserKey)
+
+	userId, ok := contextValue.(int)
+	if !ok {
+		utils.JSONResponse(w, http.StatusUnauthorized, &utils.Response{
+			Message: "Unauthorized",
+		})
+		return
+	}
+
+	query, err := NewStatsQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		var validationErrs types.ValidationErrors
+
+		if errors.As(err, &validationErrs) {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 10*time.Second)
+	defer close()
+
+	stats, err := h.service.GetUrlStats(ctx, r.PathValue("code"), userId, query)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL statistics",
+		Data:    stats,
+	})
+}
+
+func (h *handler) ListUrls(w http.ResponseWriter, r *http.Request) {
+	contextValue := r.Context().Value(middlewares.UserKey)
+
+	userId, ok := contextValue.(int)
+	if !ok {
+		utils.JSONResponse(w, http.StatusUnauthorized, &utils.Response{
+			Message: "Unauthorized",
+		})
+		return
+	}
+
+	query, err := NewListQuery(r.URL.Query())
+	if err != nil {
+		var validationErrs types.ValidationErrors
+
+		if errors.As(err, &validationErrs) {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	page, err := h.service.ListUrls(ctx, userId, query)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URLs",
+		Data:    page,
+	})
+}
+
+func (h *handler) UpdateUrl(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	var update UpdateURL
+	if !parseAndValidate(w, r, &update) {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	link, err := h.service.UpdateUrl(ctx, r.PathValue("code"), userId, update)
+	if err != nil {
+		if err == ErrActiveAfterExpiry {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    types.ValidationErrors{"active_from": "must be before expires"},
+			})
+			return
+		}
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL updated",
+		Data:    link,
+	})
+}
+
+func (h *handler) GetUrlHistory(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	history, err := h.service.GetUrlHistory(ctx, r.PathValue("code"), userId)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL history",
+		Data:    history,
+	})
+}
+
+func (h *handler) GetUrlHealth(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	health, err := h.service.GetUrlHealth(ctx, r.PathValue("code"), userId)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusNotFound)
+		writeOwnershipError(w, err)
 		return
 	}
 
-	http.Redirect(w, r, *long_url, http.StatusFound)
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL health",
+		Data:    health,
+	})
+}
+
+func (h *handler) RefreshUrlMetadata(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 15*time.Second)
+	defer close()
+
+	link, err := h.service.RefreshUrlMetadata(ctx, r.PathValue("code"), userId)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	if link.MetadataError != "" {
+		utils.JSONResponse(w, http.StatusBadGateway, &utils.Response{
+			Message: "Could not fetch the destination page: " + link.MetadataError,
+			Data:    link,
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL metadata refreshed",
+		Data:    link,
+	})
+}
+
+func (h *handler) ListUrlEvents(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	var after int64
+	if value := r.URL.Query().Get("after"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    types.ValidationErrors{"after": "must be an event id"},
+			})
+			return
+		}
+		after = n
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	events, err := h.service.ListUrlEvents(ctx, userId, after)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL events",
+		Data:    events,
+	})
+}
+
+func (h *handler) RollbackUrl(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	historyId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+			Message: "History entry not found",
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	link, err := h.service.RollbackUrl(ctx, r.PathValue("code"), userId, historyId)
+	if err != nil {
+		if err == ErrHistoryNotFound {
+			utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+				Message: "History entry not found",
+			})
+			return
+		}
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL rolled back",
+		Data:    link,
+	})
+}
+
+func (h *handler) GetUrlSchedule(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	schedule, err := h.service.GetUrlSchedule(ctx, r.PathValue("code"), userId)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL schedule",
+		Data:    schedule,
+	})
+}
+
+func (h *handler) UpdateUrlSchedule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	var update UpdateSchedule
+	if !parseAndValidate(w, r, &update) {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	schedule, err := h.service.UpdateUrlSchedule(ctx, r.PathValue("code"), userId, update.Entries)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL schedule updated",
+		Data:    schedule,
+	})
+}
+
+func (h *handler) DisableUrl(w http.ResponseWriter, r *http.Request) {
+	h.setUrlDisabled(w, r, true)
+}
+
+func (h *handler) EnableUrl(w http.ResponseWriter, r *http.Request) {
+	h.setUrlDisabled(w, r, false)
+}
+
+func (h *handler) setUrlDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	link, err := h.service.SetUrlDisabled(ctx, r.PathValue("code"), userId, disabled)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	message := "Short URL enabled"
+	if disabled {
+		message = "Short URL disabled"
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: message,
+		Data:    link,
+	})
+}
+
+func (h *handler) DeleteUrl(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	if err := h.service.DeleteUrl(ctx, r.PathValue("code"), userId); err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL moved to trash",
+	})
+}
+
+func (h *handler) RestoreUrl(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	link, err := h.service.RestoreUrl(ctx, r.PathValue("code"), userId)
+	if err != nil {
+		writeOwnershipError(w, err)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL restored",
+		Data:    link,
+	})
+}
+
+const bulkMaxBodySize = 10 << 20
+
+func (h *handler) BulkShortenURLs(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != BulkModeAtomic && mode != BulkModeBestEffort {
+		utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+			Message: "Validation error",
+			Data:    types.ValidationErrors{"mode": "must be atomic or best_effort"},
+		})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, bulkMaxBodySize)
+
+	var rows []BulkRow
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediatype == "text/csv" {
+		parsed, err := parseBulkCSV(r.Body)
+		if err != nil {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Request body contains malformed CSV",
+			})
+			return
+		}
+		rows = parsed
+	} else {
+		var urls []URL
+		if err := utils.ParseJSON(w, r, &urls); err != nil {
+			var mr *utils.MalformedRequest
+			if errors.As(err, &mr) {
+				utils.JSONResponse(w, mr.Code, &utils.Response{
+					Message: mr.Message,
+				})
+				return
+			}
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Request body must be a JSON array of URLs",
+			})
+			return
+		}
+		rows = bulkRows(urls)
+	}
+
+	if len(rows) == 0 {
+		utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+			Message: "Request body must contain at least one URL",
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 60*time.Second)
+	defer close()
+
+	report, err := h.service.CreateBulkShortUrls(ctx, rows, userId, mode)
+	if err != nil {
+		if err == ErrTooManyRows {
+			utils.JSONResponse(w, http.StatusRequestEntityTooLarge, &utils.Response{
+				Message: "Too many rows in bulk request",
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	switch {
+	case report.Failed == 0:
+		utils.JSONResponse(w, http.StatusCreated, &utils.Response{
+			Message: "Short URLs created",
+			Data:    report,
+		})
+	case report.Mode == BulkModeAtomic:
+		utils.JSONResponse(w, http.StatusUnprocessableEntity, &utils.Response{
+			Message: "No short URLs were created",
+			Data:    report,
+		})
+	default:
+		utils.JSONResponse(w, http.StatusOK, &utils.Response{
+			Message: "Some short URLs could not be created",
+			Data:    report,
+		})
+	}
+}
+
+// exportFlushEvery controls how many rows are written between flushes, so
+// large exports reach the client while they are still being read.
+const exportFlushEvery = 100
+
+// ExportUrls streams the caller's links as CSV or NDJSON. With
+// include_clicks=true NDJSON gets a "click" record after the links, while
+// CSV switches to one row per click since a spreadsheet cannot hold both.
+func (h *handler) ExportUrls(w http.ResponseWriter, r *http.Request) {
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+
+	errs := make(types.ValidationErrors)
+	if format != ExportFormatCSV && format != ExportFormatNDJSON {
+		errs["format"] = "must be csv or ndjson"
+	}
+
+	includeClicks := false
+	if value := query.Get("include_clicks"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			errs["include_clicks"] = "must be a boolean"
+		}
+		includeClicks = parsed
+	}
+
+	if len(errs) > 0 {
+		utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+			Message: "Validation error",
+			Data:    errs,
+		})
+		return
+	}
+
+	filename := "yaurl-links." + format
+	if format == ExportFormatCSV && includeClicks {
+		filename = "yaurl-clicks.csv"
+	}
+
+	if format == ExportFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	rows := 0
+	flush := func(buffered func()) {
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if buffered != nil {
+				buffered()
+			}
+			controller.Flush()
+		}
+	}
+
+	ctx := r.Context()
+
+	var err error
+	switch {
+	case format == ExportFormatCSV && includeClicks:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"code", "clicked_at", "referrer", "user_agent", "country", "accept_language", "source", "variant"})
+		err = h.service.ExportClicks(ctx, userId, func(c ExportedClick) error {
+			writer.Write([]string{
+				c.Code,
+				c.ClickedAt.Format(time.RFC3339),
+				c.Referrer,
+				c.UserAgent,
+				c.Country,
+				c.AcceptLanguage,
+				c.Source,
+				c.Variant,
+			})
+			flush(writer.Flush)
+			return writer.Error()
+		})
+		writer.Flush()
+
+	case format == ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"code", "short_url", "long_url", "created_at", "expires_at", "disabled_at", "deleted_at", "clicks"})
+		err = h.service.ExportUrls(ctx, userId, func(l Link) error {
+			writer.Write([]string{
+				l.Code,
+				l.ShortUrl,
+				l.LongUrl,
+				l.CreatedAt.Format(time.RFC3339),
+				l.ExpiresAt.Format(time.RFC3339),
+				formatOptionalTime(l.DisabledAt),
+				formatOptionalTime(l.DeletedAt),
+				strconv.FormatInt(l.Clicks, 10),
+			})
+			flush(writer.Flush)
+			return writer.Error()
+		})
+		writer.Flush()
+
+	default:
+		encoder := json.NewEncoder(w)
+		err = h.service.ExportUrls(ctx, userId, func(l Link) error {
+			flush(nil)
+			return encoder.Encode(struct {
+				Type string `json:"type"`
+				Link
+			}{"link", l})
+		})
+		if err == nil && includeClicks {
+			err = h.service.ExportClicks(ctx, userId, func(c ExportedClick) error {
+				flush(nil)
+				return encoder.Encode(struct {
+					Type string `json:"type"`
+					ExportedClick
+				}{"click", c})
+			})
+		}
+		if err != nil {
+			// The status is long sent, a last record tells the client the
+			// file is incomplete.
+			encoder.Encode(struct {
+				Type  string `json:"type"`
+				Error string `json:"error"`
+			}{"error", "Export failed, the file is incomplete"})
+		}
+	}
+
+	if err != nil {
+		h.logger.Warn("Export aborted", "user_id", userId, "rows", rows, "error", err.Error())
+		if format == ExportFormatCSV {
+			// CSV has no way to mark a partial file, cut the response
+			// short instead so the client sees a failed download.
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+const importMaxBodySize = 50 << 20
+
+func (h *handler) ImportUrls(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBodySize)
+
+	links, err := ParseImport(r.URL.Query().Get("format"), r.Body)
+	if err != nil {
+		if err == ErrUnknownImportFormat {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    types.ValidationErrors{"format": "must be one of bitly, yourls_sql, yourls_json or kutt"},
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+			Message: "Could not parse import file: " + err.Error(),
+		})
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 120*time.Second)
+	defer close()
+
+	report, err := h.service.ImportUrls(ctx, userId, links)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Import finished",
+		Data:    report,
+	})
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func requireUserId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userId, ok := r.Context().Value(middlewares.UserKey).(int)
+	if !ok {
+		utils.JSONResponse(w, http.StatusUnauthorized, &utils.Response{
+			Message: "Unauthorized",
+		})
+	}
+
+	return userId, ok
+}
+
+type validator interface {
+	Validate() error
+}
+
+// parseAndValidate decodes the JSON body into dest and validates it,
+// answering the request itself when either step fails.
+func parseAndValidate(w http.ResponseWriter, r *http.Request, dest validator) bool {
+	if err := utils.ParseJSON(w, r, dest); err != nil {
+		var mr *utils.MalformedRequest
+		if errors.As(err, &mr) {
+			utils.JSONResponse(w, mr.Code, &utils.Response{
+				Message: mr.Message,
+			})
+			return false
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return false
+	}
+
+	if err := dest.Validate(); err != nil {
+		var validationErrs types.ValidationErrors
+
+		if errors.As(err, &validationErrs) {
+			utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+				Message: "Validation error",
+				Data:    validationErrs,
+			})
+			return false
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return false
+	}
+
+	return true
+}
+
+// writeOwnershipError answers the errors shared by every endpoint that acts
+// on a single link owned by the caller, destination policy refusals
+// included.
+func writeOwnershipError(w http.ResponseWriter, err error) {
+	var validationErrs types.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		utils.JSONResponse(w, http.StatusBadRequest, &utils.Response{
+			Message: "Validation error",
+			Data:    validationErrs,
+		})
+		return
+	}
+
+	switch err {
+	case ErrNotFound:
+		utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+			Message: "Short URL not found",
+		})
+	case ErrForbidden:
+		utils.JSONResponse(w, http.StatusForbidden, &utils.Response{
+			Message: "Forbidden",
+		})
+	case ErrFlagged:
+		utils.JSONResponse(w, http.StatusConflict, &utils.Response{
+			Message: "Short URL was flagged as unsafe and must be cleared by an admin first",
+		})
+	default:
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+	}
+}
+
+// renderUnlock shows the password form, which posts back to the URL it
+// was served from so the visit's path and query survive the unlock.
+func renderUnlock(w http.ResponseWriter, status int, message string) {
+	data := struct {
+		Error string
+	}{
+		Error: message,
+	}
+
+	if err := utils.HTMLResponse(w, status, "unlock.gohtml", data); err != nil {
+		http.Error(w, "Password required", status)
+	}
+}
+
+// renderWarning replaces the redirect of a link flagged as unsafe. The
+// destination is left out on purpose.
+func renderWarning(w http.ResponseWriter, code string) {
+	data := struct {
+		ShortUrl string
+	}{
+		ShortUrl: code,
+	}
+
+	if err := utils.HTMLResponse(w, http.StatusForbidden, "warning.gohtml", data); err != nil {
+		http.Error(w, "This link was flagged as unsafe.", http.StatusForbidden)
+	}
+}
+
+func renderUnavailable(w http.ResponseWriter, status int, title, message string) {
+	data := struct {
+		Title   string
+		Message string
+	}{
+		Title:   title,
+		Message: message,
+	}
+
+	if err := utils.HTMLResponse(w, status, "unavailable.gohtml", data); err != nil {
+		http.Error(w, message, status)
+	}
+}
+
+func (h *handler) ListPreviewDomains(w http.ResponseWriter, r *http.Request) {
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	domains, err := h.service.ListPreviewDomains(ctx)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Preview domains",
+		Data:    domains,
+	})
+}
+
+func (h *handler) AddPreviewDomain(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	userId, ok := requireUserId(w, r)
+	if !ok {
+		return
+	}
+
+	var newDomain NewPreviewDomain
+	if !parseAndValidate(w, r, &newDomain) {
+		return
+	}
+
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	domain, err := h.service.AddPreviewDomain(ctx, newDomain.Domain, userId)
+	if err != nil {
+		if err == ErrDomainExists {
+			utils.JSONResponse(w, http.StatusConflict, &utils.Response{
+				Message: "Domain already listed",
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusCreated, &utils.Response{
+		Message: "Preview domain added",
+		Data:    domain,
+	})
+}
+
+func (h *handler) RemovePreviewDomain(w http.ResponseWriter, r *http.Request) {
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	if err := h.service.RemovePreviewDomain(ctx, r.PathValue("domain")); err != nil {
+		if err == ErrNotFound {
+			utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+				Message: "Preview domain not found",
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Preview domain removed",
+	})
+}
+
+func (h *handler) ReloadDestinationPolicy(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.service.ReloadDestinationPolicy()
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Failed to reload destination policy",
+			Data:    err.Error(),
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Destination policy reloaded",
+		Data:    summary,
+	})
+}
+
+func (h *handler) ListFlaggedUrls(w http.ResponseWriter, r *http.Request) {
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	links, err := h.service.ListFlaggedUrls(ctx)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Flagged short URLs",
+		Data:    links,
+	})
+}
+
+func (h *handler) ClearUrlFlag(w http.ResponseWriter, r *http.Request) {
+	ctx, close := context.WithTimeout(r.Context(), 5*time.Second)
+	defer close()
+
+	if err := h.service.ClearUrlFlag(ctx, r.PathValue("code")); err != nil {
+		if err == ErrNotFound {
+			utils.JSONResponse(w, http.StatusNotFound, &utils.Response{
+				Message: "Flagged short URL not found",
+			})
+			return
+		}
+		utils.JSONResponse(w, http.StatusInternalServerError, &utils.Response{
+			Message: "Internal Server Error",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, &utils.Response{
+		Message: "Short URL flag cleared, the owner can enable it again",
+	})
 }
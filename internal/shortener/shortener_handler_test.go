@@ -0,0 +1,286 @@
+package shortener
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+var testLogger = slog.New(slog.DiscardHandler)
+
+// stubService answers for the links in shortUrls, destinations and cards,
+// every other method panics through the nil embedded Service.
+type stubService struct {
+	Service
+	shortUrls    map[string]string
+	destinations map[string]string
+	cards        map[string]*LinkCard
+}
+
+func (s *stubService) FindLongUrl(_ context.Context, code string, _ bool) (*Destination, error) {
+	longUrl, ok := s.destinations[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &Destination{LongUrl: longUrl, Status: http.StatusFound}, nil
+}
+
+func (s *stubService) UnfurlUrl(_ context.Context, code string) (*LinkCard, error) {
+	card, ok := s.cards[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return card, nil
+}
+
+func (s *stubService) FindShortUrl(_ context.Context, code string) (*string, error) {
+	shortUrl, ok := s.shortUrls[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &shortUrl, nil
+}
+
+func TestGetQRCodeBounds(t *testing.T) {
+	h := NewHandler(&stubService{shortUrls: map[string]string{"abc": "https://yaurl.example/abc"}}, testLogger, nil, nil, nil)
+
+	tests := []struct {
+		query  string
+		status int
+		typ    string
+	}{
+		{"", http.StatusOK, "image/png"},
+		{"size=64&margin=0", http.StatusOK, "image/png"},
+		{"size=2048&margin=32&format=svg", http.StatusOK, "image/svg+xml"},
+		{"size=63", http.StatusBadRequest, ""},
+		{"size=2049", http.StatusBadRequest, ""},
+		{"size=big", http.StatusBadRequest, ""},
+		{"margin=-1", http.StatusBadRequest, ""},
+		{"margin=33", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/abc/qr?"+tt.query, nil)
+			r.SetPathValue("code", "abc")
+			w := httptest.NewRecorder()
+
+			h.GetQRCode(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.typ != "" && w.Header().Get("Content-Type") != tt.typ {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.typ)
+			}
+		})
+	}
+}
+
+func TestGetQRCodeNotFound(t *testing.T) {
+	h := NewHandler(&stubService{}, testLogger, nil, nil, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/nope/qr", nil)
+	r.SetPathValue("code", "nope")
+	w := httptest.NewRecorder()
+
+	h.GetQRCode(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+const (
+	slackbot = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	firefox  = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+)
+
+// newRedirectHandler serves abc, whose destination is never to show up in
+// a card, and counts the clicks it records.
+func newRedirectHandler(t *testing.T, cfg *config.Config) (*handler, *ClickRecorder) {
+	// Pages are rendered from templates/ at the repository root.
+	t.Chdir("../..")
+
+	visitors := NewVisitors(cfg, nil)
+	clicks := &ClickRecorder{cfg: cfg, visitors: visitors, clicks: make(chan Click, 10)}
+	service := &stubService{
+		destinations: map[string]string{"abc": "https://dest.example/secret"},
+		cards: map[string]*LinkCard{"abc": newLinkCard("https://yaurl.example/abc", false, &LinkMetadata{}, &SocialCard{
+			Title:       "Launch notes",
+			Description: "What shipped this week.",
+			Image:       "https://cdn.example/og.png",
+		})},
+	}
+
+	return NewHandler(service, testLogger, clicks, visitors, nil), clicks
+}
+
+func serveRedirect(h *handler, code, userAgent string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	r.SetPathValue("code", code)
+	r.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+
+	h.RedirectUrl(w, r)
+
+	return w
+}
+
+func TestRedirectUrlUnfurl(t *testing.T) {
+	h, clicks := newRedirectHandler(t, &config.Config{UNFURL_ENABLED: true})
+
+	w := serveRedirect(h, "abc", slackbot)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Vary"); got != "User-Agent" {
+		t.Errorf("Vary = %q, want User-Agent", got)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`<title>Launch notes</title>`,
+		`<meta property="og:title" content="Launch notes">`,
+		`<meta property="og:description" content="What shipped this week.">`,
+		`<meta property="og:image" content="https://cdn.example/og.png">`,
+		`<meta property="og:url" content="https://yaurl.example/abc">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+	if strings.Contains(body, "dest.example") {
+		t.Errorf("page reveals the destination")
+	}
+
+	if len(clicks.clicks) != 0 {
+		t.Errorf("crawler visit recorded %d clicks, want none", len(clicks.clicks))
+	}
+}
+
+func TestRedirectUrlBrowser(t *testing.T) {
+	h, clicks := newRedirectHandler(t, &config.Config{UNFURL_ENABLED: true})
+
+	w := serveRedirect(h, "abc", firefox)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if got := w.Header().Get("Location"); got != "https://dest.example/secret" {
+		t.Errorf("Location = %q, want the destination", got)
+	}
+	if len(clicks.clicks) != 1 {
+		t.Errorf("browser visit recorded %d clicks, want 1", len(clicks.clicks))
+	}
+}
+
+func TestRedirectUrlUnfurlConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *config.Config
+		userAgent string
+		status    int
+	}{
+		{"disabled", &config.Config{UNFURL_ENABLED: false}, slackbot, http.StatusFound},
+		{"extra user agent", &config.Config{UNFURL_ENABLED: true, UNFURL_USER_AGENTS: " Mattermost , "}, "mattermost-1.0", http.StatusOK},
+		{"unknown user agent", &config.Config{UNFURL_ENABLED: true, UNFURL_USER_AGENTS: "mattermost"}, firefox, http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newRedirectHandler(t, tt.cfg)
+
+			if w := serveRedirect(h, "abc", tt.userAgent); w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestRedirectUrlUnfurlNotFound(t *testing.T) {
+	h, _ := newRedirectHandler(t, &config.Config{UNFURL_ENABLED: true})
+
+	if w := serveRedirect(h, "nope", slackbot); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouteVariantCookie(t *testing.T) {
+	h := NewHandler(nil, testLogger, nil, NewVisitors(&config.Config{}, nil), nil)
+	destination := &Destination{
+		LongUrl: "https://example.com/",
+		Variants: Variants{
+			{Name: "a", Url: "https://example.com/a", Weight: 1},
+			{Name: "b", Url: "https://example.com/b", Weight: 1},
+		},
+	}
+
+	route := func(cookie string) (Destination, *http.Cookie) {
+		r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		r.RemoteAddr = "192.0.2.10:5000"
+		r.Header.Set("User-Agent", firefox)
+		if cookie != "" {
+			r.Header.Set("Cookie", variantCookie+"="+cookie)
+		}
+		w := httptest.NewRecorder()
+
+		routed := h.route(w, r, "abc", destination)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return routed, nil
+		}
+		return routed, cookies[0]
+	}
+
+	first, set := route("")
+	if first.Variant == "" {
+		t.Fatal("no variant picked")
+	}
+	if set == nil || set.Value != first.Variant || set.Path != "/abc" {
+		t.Fatalf("cookie = %v, want %s scoped to /abc", set, first.Variant)
+	}
+
+	other := "a"
+	if first.Variant == "a" {
+		other = "b"
+	}
+
+	tests := []struct {
+		name      string
+		cookie    string
+		want      string
+		setCookie bool
+	}{
+		{"same visitor without the cookie", "", first.Variant, true},
+		{"cookie kept", other, other, false},
+		{"stale cookie replaced", "retired", first.Variant, true},
+		{"garbage cookie replaced", "%zz", first.Variant, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routed, set := route(tt.cookie)
+			if routed.Variant != tt.want || routed.LongUrl != "https://example.com/"+tt.want {
+				t.Errorf("routed to %q (%s), want %q", routed.Variant, routed.LongUrl, tt.want)
+			}
+			if (set != nil) != tt.setCookie {
+				t.Errorf("cookie = %v, want set: %v", set, tt.setCookie)
+			}
+			if set != nil && set.Value != tt.want {
+				t.Errorf("cookie value = %q, want %q", set.Value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+// healthHistoryLimit is how many checks GetUrlHealth returns.
+const healthHistoryLimit = 50
+
+// healthProber sends the requests of a health check run. Destinations are
+// grouped by host and every host is visited by one worker at a time, with
+// a pause between its requests, so a host with many links isn't hammered.
+type healthProber struct {
+	client      *http.Client
+	userAgent   string
+	concurrency int
+	hostDelay   time.Duration
+}
+
+type probeResult struct {
+	healthy    bool
+	statusCode int
+	err        string
+	duration   time.Duration
+}
+
+func newHealthProber(cfg *config.Config) *healthProber {
+	return &healthProber{
+		client:      newOutboundClient(cfg.HEALTH_CHECK_TIMEOUT),
+		userAgent:   "yaurl-health-check/1.0 (+" + cfg.APP_BASE_URL + ")",
+		concurrency: max(cfg.HEALTH_CHECK_CONCURRENCY, 1),
+		hostDelay:   cfg.HEALTH_HOST_DELAY,
+	}
+}
+
+// probeAll checks every URL once. URLs left unchecked when ctx ends are
+// missing from the result.
+func (p *healthProber) probeAll(ctx context.Context, urls []string) map[string]probeResult {
+	byHost := make(map[string][]string)
+	for _, rawUrl := range urls {
+		host := ""
+		if parsed, err := url.Parse(rawUrl); err == nil {
+			host = strings.ToLower(parsed.Hostname())
+		}
+		byHost[host] = append(byHost[host], rawUrl)
+	}
+
+	hosts := make(chan []string)
+	results := make(map[string]probeResult, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for range min(p.concurrency, len(byHost)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for group := range hosts {
+				for i, rawUrl := range group {
+					if i > 0 && !sleepContext(ctx, p.hostDelay) {
+						break
+					}
+
+					result := p.probe(ctx, rawUrl)
+					if ctx.Err() != nil {
+						break
+					}
+
+					mu.Lock()
+					results[rawUrl] = result
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, group := range byHost {
+		select {
+		case hosts <- group:
+		case <-ctx.Done():
+		}
+	}
+	close(hosts)
+	wg.Wait()
+
+	return results
+}
+
+// probe tries HEAD first. Plenty of servers answer HEAD wrongly, so a
+// refusal or an error status is confirmed with a GET.
+func (p *healthProber) probe(ctx context.Context, rawUrl string) probeResult {
+	start := time.Now()
+
+	status, err := p.request(ctx, http.MethodHead, rawUrl)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || deadStatus(status)) {
+		status, err = p.request(ctx, http.MethodGet, rawUrl)
+	}
+
+	result := probeResult{duration: time.Since(start)}
+	if err != nil {
+		result.err = outboundError(err)
+		return result
+	}
+
+	result.statusCode = status
+	result.healthy = !deadStatus(status)
+
+	return result
+}
+
+func (p *healthProber) request(ctx context.Context, method, rawUrl string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// A little of the body is drained so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	return resp.StatusCode, nil
+}
+
+// deadStatus reports whether status means the destination is gone or
+// broken. Other client errors, like 401, 403 or 429, come from a server
+// that is up but turned the checker away.
+func deadStatus(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// healthTarget is a link due for a check, Url being the destination
+// visitors currently get.
+type healthTarget struct {
+	id         int
+	code       string
+	url        string
+	status     string
+	checkedUrl *string
+	failures   int
+}
+
+// CheckLinkHealth checks the destinations of the links that went longest
+// without a check, up to HEALTH_CHECK_BATCH_SIZE of them. A link turns
+// failing after HEALTH_FAILURE_THRESHOLD failed checks in a row and healthy
+// again after one that passes, both changes leave an event for the owner.
+// It returns how many links were checked.
+func (s *service) CheckLinkHealth(ctx context.Context) (int, error) {
+	targets, err := s.healthTargets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var urls []string
+	seen := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		if _, ok := seen[target.url]; !ok {
+			seen[target.url] = struct{}{}
+			urls = append(urls, target.url)
+		}
+	}
+
+	results := s.prober.probeAll(ctx, urls)
+
+	checked := 0
+	for _, target := range targets {
+		result, ok := results[target.url]
+		if !ok {
+			continue
+		}
+		if err := s.recordHealth(ctx, target, result); err != nil {
+			return checked, err
+		}
+		checked++
+	}
+
+	if s.cfg.HEALTH_HISTORY_RETENTION > 0 {
+		_, err := s.db.ExecContext(
+			ctx,
+			"DELETE FROM url_health_checks WHERE checked_at < $1",
+			time.Now().Add(-s.cfg.HEALTH_HISTORY_RETENTION),
+		)
+		if err != nil {
+			s.logger.Error("Failed to prune health checks", "error", err.Error())
+			return checked, ErrExecQuery
+		}
+	}
+
+	s.logger.Info("Link health check finished", "links", checked, "destinations", len(results))
+
+	return checked, nil
+}
+
+func (s *service) healthTargets(ctx context.Context) ([]healthTarget, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		fmt.Sprintf(
+			`SELECT u.id, u.short_url, %s, u.health_status, u.health_url, u.health_failures
+			FROM urls u
+			WHERE u.deleted_at IS NULL AND u.disabled_at IS NULL AND u.flagged_at IS NULL AND u.expires_at > NOW()
+			ORDER BY u.health_checked_at NULLS FIRST, u.id
+			LIMIT $1`,
+			sqlCurrentLongUrl,
+		),
+		max(s.cfg.HEALTH_CHECK_BATCH_SIZE, 1),
+	)
+	if err != nil {
+		s.logger.Error("Failed to query urls for health check", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	var targets []healthTarget
+	for rows.Next() {
+		var target healthTarget
+		if err := rows.Scan(&target.id, &target.code, &target.url, &target.status, &target.checkedUrl, &target.failures); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return targets, nil
+}
+
+// recordHealth stores result and moves the link to its new health. The
+// streak starts over whenever the destination changed since the last
+// check.
+func (s *service) recordHealth(ctx context.Context, target healthTarget, result probeResult) error {
+	previous, failures := target.status, target.failures
+	if target.checkedUrl == nil || *target.checkedUrl != target.url {
+		previous, failures = HealthUnknown, 0
+	}
+
+	status := previous
+	if result.healthy {
+		status, failures = HealthHealthy, 0
+	} else {
+		failures++
+		if failures >= max(s.cfg.HEALTH_FAILURE_THRESHOLD, 1) {
+			status = HealthFailing
+		}
+	}
+
+	var statusCode *int
+	if result.statusCode != 0 {
+		statusCode = &result.statusCode
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin health transaction", "error", err.Error())
+		return ErrExecQuery
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO url_health_checks (url_id, url, healthy, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		target.id,
+		target.url,
+		result.healthy,
+		statusCode,
+		result.err,
+		result.duration.Milliseconds(),
+	)
+	if err != nil {
+		s.logger.Error("Failed to insert health check", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE urls SET health_status = $2, health_url = $3, health_checked_at = NOW(), health_failures = $4
+		WHERE id = $1`,
+		target.id,
+		status,
+		target.url,
+		failures,
+	)
+	if err != nil {
+		s.logger.Error("Failed to update url health", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	kind, detail := "", ""
+	switch {
+	case status == HealthFailing && previous != HealthFailing:
+		kind = EventLinkFailing
+		detail = fmt.Sprintf("%s failed %d checks in a row, last: %s", target.url, failures, result.describe())
+	case status == HealthHealthy && previous == HealthFailing:
+		kind = EventLinkRecovered
+		detail = fmt.Sprintf("%s is reachable again: %s", target.url, result.describe())
+	}
+
+	if kind != "" {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO url_events (url_id, kind, detail) VALUES ($1, $2, $3)",
+			target.id,
+			kind,
+			detail,
+		)
+		if err != nil {
+			s.logger.Error("Failed to insert url event", "error", err.Error())
+			return ErrExecQuery
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit health transaction", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	if kind != "" {
+		s.logger.Info("Short URL health changed", "code", target.code, "health", status, "detail", detail)
+	}
+
+	return nil
+}
+
+func (r probeResult) describe() string {
+	if r.err != "" {
+		return r.err
+	}
+
+	return fmt.Sprintf("%d %s", r.statusCode, http.StatusText(r.statusCode))
+}
+
+func (s *service) GetUrlHealth(ctx context.Context, code string, userId int) (*LinkHealth, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	health := LinkHealth{History: []HealthCheck{}}
+
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT health_status, health_url, health_checked_at, health_failures, fallback_url FROM urls WHERE id = $1",
+		urlId,
+	)
+	if err := row.Scan(&health.Status, &health.Url, &health.CheckedAt, &health.Failures, &health.FallbackUrl); err != nil {
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT checked_at, url, healthy, status_code, error, duration_ms
+		FROM url_health_checks WHERE url_id = $1
+		ORDER BY checked_at DESC LIMIT $2`,
+		urlId,
+		healthHistoryLimit,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query health checks", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var check HealthCheck
+		if err := rows.Scan(&check.CheckedAt, &check.Url, &check.Healthy, &check.StatusCode, &check.Error, &check.DurationMs); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		health.History = append(health.History, check)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return &health, nil
+}
+
+// eventsLimit is how many events ListUrlEvents returns at most.
+const eventsLimit = 100
+
+// ListUrlEvents returns the events of the links owned by userId that came
+// after the id after, oldest first and at most eventsLimit of them. Clients
+// poll with the highest id they have seen and get the rest on the next call.
+func (s *service) ListUrlEvents(ctx context.Context, userId int, after int64) ([]LinkEvent, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT e.id, u.short_url, e.kind, e.detail, e.created_at
+		FROM url_events e JOIN urls u ON u.id = e.url_id
+		WHERE u.user_id = $1 AND e.id > $2
+		ORDER BY e.id ASC LIMIT $3`,
+		userId,
+		after,
+		eventsLimit,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query url events", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	events := []LinkEvent{}
+	for rows.Next() {
+		var event LinkEvent
+		if err := rows.Scan(&event.Id, &event.Code, &event.Kind, &event.Detail, &event.CreatedAt); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return events, nil
+}
@@ -0,0 +1,127 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventsConnector serves the url events query from an in-memory list, so
+// ListUrlEvents can be tested without a database.
+type eventsConnector struct {
+	t      *testing.T
+	events []LinkEvent
+}
+
+func (c *eventsConnector) Connect(context.Context) (driver.Conn, error) {
+	return &eventsConn{c}, nil
+}
+
+func (c *eventsConnector) Driver() driver.Driver { return nil }
+
+type eventsConn struct{ c *eventsConnector }
+
+func (c *eventsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare is not supported")
+}
+
+func (c *eventsConn) Close() error { return nil }
+
+func (c *eventsConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (c *eventsConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, "ORDER BY e.id ASC") {
+		c.c.t.Errorf("events query does not order by ascending id:\n%s", query)
+	}
+
+	after := args[1].Value.(int64)
+	limit := args[2].Value.(int64)
+
+	rows := &eventsRows{}
+	for _, event := range c.c.events {
+		if event.Id > after && int64(len(rows.events)) < limit {
+			rows.events = append(rows.events, event)
+		}
+	}
+	return rows, nil
+}
+
+type eventsRows struct {
+	events []LinkEvent
+	next   int
+}
+
+func (r *eventsRows) Columns() []string {
+	return []string{"id", "short_url", "kind", "detail", "created_at"}
+}
+
+func (r *eventsRows) Close() error { return nil }
+
+func (r *eventsRows) Next(dest []driver.Value) error {
+	if r.next == len(r.events) {
+		return io.EOF
+	}
+	event := r.events[r.next]
+	r.next++
+	dest[0] = event.Id
+	dest[1] = event.Code
+	dest[2] = event.Kind
+	dest[3] = event.Detail
+	dest[4] = event.CreatedAt
+	return nil
+}
+
+func TestListUrlEventsPaging(t *testing.T) {
+	const total = 250
+
+	connector := &eventsConnector{t: t}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= total; i++ {
+		connector.events = append(connector.events, LinkEvent{
+			Id:        int64(i),
+			Code:      "abc",
+			Kind:      "clicked",
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	s := &service{db: db, logger: testLogger}
+
+	var after int64
+	var pages int
+	for {
+		events, err := s.ListUrlEvents(context.Background(), 1, after)
+		if err != nil {
+			t.Fatalf("ListUrlEvents(after=%d): %v", after, err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		if len(events) > eventsLimit {
+			t.Fatalf("page has %d events, want at most %d", len(events), eventsLimit)
+		}
+		for _, event := range events {
+			if event.Id != after+1 {
+				t.Fatalf("got event %d after %d, want %d", event.Id, after, after+1)
+			}
+			after = event.Id
+		}
+		pages++
+	}
+
+	if after != total {
+		t.Errorf("last event = %d, want %d", after, total)
+	}
+	if pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+}
@@ -0,0 +1,517 @@
+package shortener
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ImportFormatBitly      = "bitly"
+	ImportFormatYourlsSQL  = "yourls_sql"
+	ImportFormatYourlsJSON = "yourls_json"
+	ImportFormatKutt       = "kutt"
+)
+
+var ErrUnknownImportFormat = errors.New("Unknown import format")
+
+// ImportedLink is a link read from another shortener's export, Code is the
+// original short code and is kept as the alias.
+type ImportedLink struct {
+	Code      string
+	Url       string
+	CreatedAt *time.Time
+	ExpiresAt *time.Time
+}
+
+func ParseImport(format string, r io.Reader) ([]ImportedLink, error) {
+	switch format {
+	case ImportFormatBitly:
+		return parseBitlyCSV(r)
+	case ImportFormatYourlsSQL:
+		return parseYourlsSQL(r)
+	case ImportFormatYourlsJSON:
+		return parseYourlsJSON(r)
+	case ImportFormatKutt:
+		return parseKuttJSON(r)
+	default:
+		return nil, ErrUnknownImportFormat
+	}
+}
+
+// parseBitlyCSV reads a Bitly link export. Column names have changed over
+// the years, so the first matching alias of each column is used.
+func parseBitlyCSV(r io.Reader) ([]ImportedLink, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading bitly header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[strings.ReplaceAll(name, " ", "_")] = i
+	}
+
+	find := func(record []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(record) && strings.TrimSpace(record[i]) != "" {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	var links []ImportedLink
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bitly row: %w", err)
+		}
+
+		// A custom back-half is what people actually shared, prefer it
+		// over the generated bitlink. Several custom links may be listed.
+		short := find(record, "custom_bitlinks", "custom_link", "custom_links")
+		short, _, _ = strings.Cut(short, ",")
+		if short == "" {
+			short = find(record, "bitlink", "link", "short_url", "short_link", "id")
+		}
+
+		links = append(links, ImportedLink{
+			Code:      codeFromShortUrl(short),
+			Url:       find(record, "long_url", "destination", "original_url", "url"),
+			CreatedAt: parseImportTime(find(record, "created_at", "created", "date_created", "date")),
+		})
+	}
+
+	return links, nil
+}
+
+// parseYourlsSQL reads the INSERT statements of a mysqldump of the
+// yourls_url table. Statements for other tables are skipped.
+func parseYourlsSQL(r io.Reader) ([]ImportedLink, error) {
+	data, err := io.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	var links []ImportedLink
+	p := newSQLDumpParser(string(data))
+
+	for {
+		table, columns, ok := p.nextInsert()
+		if !ok {
+			break
+		}
+
+		if !strings.HasSuffix(strings.ToLower(table), "url") {
+			p.skipStatement()
+			continue
+		}
+		if columns == nil {
+			columns = []string{"keyword", "url", "title", "timestamp", "ip", "clicks"}
+		}
+
+		for {
+			values, err := p.nextTuple()
+			if err != nil {
+				return nil, err
+			}
+			if values == nil {
+				break
+			}
+
+			row := make(map[string]string, len(columns))
+			for i, column := range columns {
+				if i < len(values) {
+					row[strings.ToLower(column)] = values[i]
+				}
+			}
+
+			links = append(links, ImportedLink{
+				Code:      row["keyword"],
+				Url:       row["url"],
+				CreatedAt: parseImportTime(row["timestamp"]),
+			})
+		}
+	}
+
+	if links == nil {
+		return nil, errors.New("no yourls_url INSERT statements found")
+	}
+
+	return links, nil
+}
+
+// parseYourlsJSON accepts the output of the YOURLS stats API
+// ({"links": {"link_1": {...}}}) as well as a plain array of rows, which is
+// what phpMyAdmin's JSON export of yourls_url produces.
+func parseYourlsJSON(r io.Reader) ([]ImportedLink, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]any
+
+	var stats struct {
+		Links map[string]map[string]any `json:"links"`
+	}
+	if err := json.Unmarshal(raw, &stats); err == nil && stats.Links != nil {
+		for _, row := range stats.Links {
+			rows = append(rows, row)
+		}
+	} else {
+		var items []map[string]any
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, errors.New("expected a YOURLS stats object or an array of rows")
+		}
+		for _, item := range items {
+			// phpMyAdmin wraps each table as {"type": "table", "data": [...]}.
+			if data, ok := item["data"].([]any); ok {
+				for _, d := range data {
+					if row, ok := d.(map[string]any); ok {
+						rows = append(rows, row)
+					}
+				}
+				continue
+			}
+			if _, ok := item["type"]; !ok {
+				rows = append(rows, item)
+			}
+		}
+	}
+
+	links := make([]ImportedLink, 0, len(rows))
+	for _, row := range rows {
+		code := jsonString(row, "keyword")
+		if code == "" {
+			code = codeFromShortUrl(jsonString(row, "shorturl"))
+		}
+
+		links = append(links, ImportedLink{
+			Code:      code,
+			Url:       jsonString(row, "url"),
+			CreatedAt: parseImportTime(jsonString(row, "timestamp")),
+		})
+	}
+
+	return links, nil
+}
+
+// parseKuttJSON accepts the {"data": [...]} page returned by Kutt's
+// /api/v2/links endpoint or a plain array of links.
+func parseKuttJSON(r io.Reader) ([]ImportedLink, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]any
+
+	var page struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &page); err == nil && page.Data != nil {
+		rows = page.Data
+	} else if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, errors.New("expected a Kutt links page or an array of links")
+	}
+
+	links := make([]ImportedLink, 0, len(rows))
+	for _, row := range rows {
+		code := jsonString(row, "address")
+		if code == "" {
+			code = codeFromShortUrl(jsonString(row, "link"))
+		}
+
+		links = append(links, ImportedLink{
+			Code:      code,
+			Url:       jsonString(row, "target"),
+			CreatedAt: parseImportTime(jsonString(row, "created_at")),
+			ExpiresAt: parseImportTime(jsonString(row, "expire_in")),
+		})
+	}
+
+	return links, nil
+}
+
+// codeFromShortUrl returns the last path segment of a short link such as
+// https://bit.ly/abc or bit.ly/abc.
+func codeFromShortUrl(short string) string {
+	short = strings.TrimSpace(short)
+	if short == "" {
+		return ""
+	}
+
+	if !strings.Contains(short, "://") {
+		short = "https://" + short
+	}
+
+	u, err := url.Parse(short)
+	if err != nil {
+		return ""
+	}
+
+	path := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[i+1:]
+	}
+
+	return path
+}
+
+func jsonString(row map[string]any, key string) string {
+	switch v := row[key].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return fmt.Sprint(v)
+	default:
+		return ""
+	}
+}
+
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700 MST",
+	time.DateOnly,
+}
+
+func parseImportTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+// sqlDumpParser walks the INSERT statements of a MySQL dump. It only
+// understands as much SQL as mysqldump and phpMyAdmin emit for plain rows.
+type sqlDumpParser struct {
+	src   string
+	upper string
+	pos   int
+}
+
+func newSQLDumpParser(src string) *sqlDumpParser {
+	// Keywords are matched against an ASCII upper-cased copy, which keeps
+	// byte offsets identical to src.
+	upper := []byte(src)
+	for i, c := range upper {
+		if 'a' <= c && c <= 'z' {
+			upper[i] = c - ('a' - 'A')
+		}
+	}
+
+	return &sqlDumpParser{src: src, upper: string(upper)}
+}
+
+// nextInsert moves past the next "INSERT INTO table (columns) VALUES" and
+// returns the table and, when listed, the column names.
+func (p *sqlDumpParser) nextInsert() (string, []string, bool) {
+	for {
+		i := strings.Index(p.upper[p.pos:], "INSERT INTO")
+		if i < 0 {
+			return "", nil, false
+		}
+		p.pos += i + len("INSERT INTO")
+
+		p.skipSpace()
+		table := p.identifier()
+		for p.peek() == '.' {
+			p.pos++
+			table = p.identifier()
+		}
+		if table == "" {
+			continue
+		}
+
+		p.skipSpace()
+		var columns []string
+		if p.peek() == '(' {
+			p.pos++
+			for {
+				p.skipSpace()
+				columns = append(columns, p.identifier())
+				p.skipSpace()
+				if p.peek() == ',' {
+					p.pos++
+					continue
+				}
+				if p.peek() == ')' {
+					p.pos++
+				}
+				break
+			}
+		}
+
+		p.skipSpace()
+		if !strings.HasPrefix(p.upper[p.pos:], "VALUES") {
+			continue
+		}
+		p.pos += len("VALUES")
+
+		return table, columns, true
+	}
+}
+
+// nextTuple returns the values of the next "(...)" of the current
+// statement, or nil once the statement ends.
+func (p *sqlDumpParser) nextTuple() ([]string, error) {
+	p.skipSpace()
+	if p.peek() == ',' {
+		p.pos++
+		p.skipSpace()
+	}
+	if p.peek() != '(' {
+		p.skipStatement()
+		return nil, nil
+	}
+	p.pos++
+
+	var values []string
+	for {
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return values, nil
+		default:
+			return nil, fmt.Errorf("unexpected character at offset %d", p.pos)
+		}
+	}
+}
+
+func (p *sqlDumpParser) value() (string, error) {
+	if p.peek() != '\'' {
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] != ',' && p.src[p.pos] != ')' {
+			p.pos++
+		}
+		value := strings.TrimSpace(p.src[start:p.pos])
+		if strings.EqualFold(value, "NULL") {
+			return "", nil
+		}
+		return value, nil
+	}
+
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '0':
+				b.WriteByte(0)
+			default:
+				b.WriteByte(e)
+			}
+		case c == '\'' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\'':
+			b.WriteByte('\'')
+			p.pos++
+		case c == '\'':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+
+	return "", errors.New("unterminated string in SQL dump")
+}
+
+func (p *sqlDumpParser) identifier() string {
+	start := p.pos
+	if p.peek() == '`' {
+		end := strings.IndexByte(p.src[p.pos+1:], '`')
+		if end < 0 {
+			return ""
+		}
+		p.pos += end + 2
+		return p.src[start+1 : p.pos-1]
+	}
+
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+			break
+		}
+		p.pos++
+	}
+
+	return p.src[start:p.pos]
+}
+
+// skipStatement moves past the next semicolon that is not inside a string.
+func (p *sqlDumpParser) skipStatement() {
+	inString := false
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case inString && c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+		case c == '\'':
+			inString = !inString
+		case !inString && c == ';':
+			p.pos++
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *sqlDumpParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *sqlDumpParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
@@ -0,0 +1,247 @@
+package shortener
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importedLink is an ImportedLink with its times formatted, which keeps
+// the expectations readable.
+type importedLink struct {
+	Code, Url, CreatedAt, ExpiresAt string
+}
+
+func flattenImport(links []ImportedLink) []importedLink {
+	format := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	flat := make([]importedLink, 0, len(links))
+	for _, link := range links {
+		flat = append(flat, importedLink{link.Code, link.Url, format(link.CreatedAt), format(link.ExpiresAt)})
+	}
+
+	return flat
+}
+
+func TestParseImportFixtures(t *testing.T) {
+	tests := []struct {
+		format  string
+		fixture string
+		want    []importedLink
+	}{
+		{
+			format:  ImportFormatBitly,
+			fixture: "bitly.csv",
+			want: []importedLink{
+				{"3xYz12", "https://example.com/landing?utm_source=bitly", "2023-04-05T10:20:30Z", ""},
+				{"docs", "https://example.com/docs", "2023-04-06T08:00:00Z", ""},
+				{"5dEf56", "https://example.com/short-row", "", ""},
+			},
+		},
+		{
+			format:  ImportFormatYourlsSQL,
+			fixture: "yourls.sql",
+			want: []importedLink{
+				{"gh", "https://github.com/", "2022-01-02T03:04:05Z", ""},
+				{"quote", "https://example.com/?q=it's", "2022-02-03T04:05:06Z", ""},
+				{"nulls", "https://example.com/nulls", "2022-03-04T05:06:07Z", ""},
+				{"listed", "https://example.com/listed", "2022-04-05T06:07:08Z", ""},
+				{"nodate", "https://example.com/nodate", "", ""},
+			},
+		},
+		{
+			format:  ImportFormatYourlsJSON,
+			fixture: "yourls_stats.json",
+			want: []importedLink{
+				{"abc", "https://example.com/a", "2021-05-06T07:08:09Z", ""},
+				{"kw", "https://example.com/b", "2021-05-07T07:08:09Z", ""},
+			},
+		},
+		{
+			format:  ImportFormatYourlsJSON,
+			fixture: "yourls_phpmyadmin.json",
+			want: []importedLink{
+				{"one", "https://example.com/one", "2020-01-01T00:00:00Z", ""},
+				{"two", "https://example.com/two", "2020-01-02T00:00:00Z", ""},
+			},
+		},
+		{
+			format:  ImportFormatKutt,
+			fixture: "kutt.json",
+			want: []importedLink{
+				{"promo", "https://example.com/promo", "2023-07-01T12:00:00Z", "2024-07-01T12:00:00Z"},
+				{"xYz9", "https://example.com/random", "2023-07-02T12:00:00Z", ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "import", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			links, err := ParseImport(tt.format, f)
+			if err != nil {
+				t.Fatalf("ParseImport: %v", err)
+			}
+
+			got := flattenImport(links)
+			if tt.format == ImportFormatYourlsJSON {
+				// The stats API keys links by name, their order is lost.
+				slices.SortFunc(got, func(a, b importedLink) int { return strings.Compare(a.Code, b.Code) })
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("links =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"bitly empty", ImportFormatBitly, ""},
+		{"bitly bare quote", ImportFormatBitly, "Bitlink,Long URL\nbit.ly/a,\"https://example.com\n"},
+		{"sql no inserts", ImportFormatYourlsSQL, "CREATE TABLE `yourls_url` (`keyword` varchar(100));"},
+		{"sql other tables only", ImportFormatYourlsSQL, "INSERT INTO `yourls_log` VALUES (1,'a');"},
+		{"sql unterminated string", ImportFormatYourlsSQL, "INSERT INTO `yourls_url` VALUES ('a','https://example.com"},
+		{"sql missing comma", ImportFormatYourlsSQL, "INSERT INTO `yourls_url` VALUES ('a' 'b');"},
+		{"sql unterminated tuple", ImportFormatYourlsSQL, "INSERT INTO `yourls_url` VALUES ('a',1"},
+		{"yourls json scalar", ImportFormatYourlsJSON, `"links"`},
+		{"yourls json truncated", ImportFormatYourlsJSON, `{"links": {`},
+		{"kutt json object", ImportFormatKutt, `{"data": "nope"}`},
+		{"kutt json truncated", ImportFormatKutt, `[{"address": "a"`},
+		{"unknown format", "tinyurl", "anything"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links, err := ParseImport(tt.format, strings.NewReader(tt.input))
+			if err == nil {
+				t.Errorf("ParseImport returned %v, want an error", flattenImport(links))
+			}
+		})
+	}
+
+	if _, err := ParseImport("tinyurl", strings.NewReader("")); !errors.Is(err, ErrUnknownImportFormat) {
+		t.Errorf("unknown format error = %v, want %v", err, ErrUnknownImportFormat)
+	}
+}
+
+func TestParseYourlsSQLTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []importedLink
+	}{
+		{
+			name:  "backslash escapes",
+			input: `INSERT INTO yourls_url VALUES ('a\'b','https://example.com/\\path\t','t','2022-01-01 00:00:00','ip',0);`,
+			want:  []importedLink{{"a'b", "https://example.com/\\path\t", "2022-01-01T00:00:00Z", ""}},
+		},
+		{
+			name:  "doubled quotes",
+			input: `INSERT INTO yourls_url VALUES ('it''s','https://example.com/','','','',0);`,
+			want:  []importedLink{{"it's", "https://example.com/", "", ""}},
+		},
+		{
+			name:  "column list in another order",
+			input: "INSERT INTO `yourls_url` (`URL`,`Keyword`) VALUES ('https://example.com/', 'k');",
+			want:  []importedLink{{"k", "https://example.com/", "", ""}},
+		},
+		{
+			name:  "short tuple",
+			input: "INSERT INTO yourls_url (keyword, url, timestamp) VALUES ('k');",
+			want:  []importedLink{{"k", "", "", ""}},
+		},
+		{
+			name: "several statements and tables",
+			input: "INSERT INTO yourls_log VALUES (1,'x;INSERT INTO yourls_url VALUES (\\'fake\\')');\n" +
+				"INSERT INTO yourls_url VALUES ('a','https://a.example/','','','',0),('b','https://b.example/','','','',0);\n" +
+				"INSERT INTO yourls_url VALUES ('c','https://c.example/','','','',0)",
+			want: []importedLink{
+				{"a", "https://a.example/", "", ""},
+				{"b", "https://b.example/", "", ""},
+				{"c", "https://c.example/", "", ""},
+			},
+		},
+		{
+			name:  "statement without VALUES is skipped",
+			input: "INSERT INTO yourls_url SELECT * FROM old_url; INSERT INTO yourls_url VALUES ('k','https://example.com/','','','',0);",
+			want:  []importedLink{{"k", "https://example.com/", "", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links, err := parseYourlsSQL(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("parseYourlsSQL: %v", err)
+			}
+			if got := flattenImport(links); !slices.Equal(got, tt.want) {
+				t.Errorf("links = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeFromShortUrl(t *testing.T) {
+	tests := []struct {
+		short string
+		want  string
+	}{
+		{"https://bit.ly/abc", "abc"},
+		{"bit.ly/abc", "abc"},
+		{"  bit.ly/abc/  ", "abc"},
+		{"https://kutt.it/a/b?x=1#y", "b"},
+		{"https://bit.ly", ""},
+		{"https://bit.ly/", ""},
+		{"", ""},
+		{"https://bit.ly/%zz", ""},
+	}
+
+	for _, tt := range tests {
+		if got := codeFromShortUrl(tt.short); got != tt.want {
+			t.Errorf("codeFromShortUrl(%q) = %q, want %q", tt.short, got, tt.want)
+		}
+	}
+}
+
+// FuzzParseImport checks that no input makes a parser panic or hang. The
+// fixtures are part of the seed corpus, so plain go test runs them too.
+func FuzzParseImport(f *testing.F) {
+	for _, fixture := range []string{"bitly.csv", "yourls.sql", "yourls_stats.json", "kutt.json"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "import", fixture))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+	f.Add("INSERT INTO `")
+	f.Add("INSERT INTO yourls_url (")
+	f.Add("INSERT INTO yourls_url (`a")
+	f.Add("INSERT INTO yourls_url VALUES (")
+	f.Add("INSERT INTO yourls_url VALUES ('\\")
+	f.Add("INSERT INTO yourls_url VALUES ,,,")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, format := range []string{ImportFormatBitly, ImportFormatYourlsSQL, ImportFormatYourlsJSON, ImportFormatKutt} {
+			ParseImport(format, strings.NewReader(input))
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package shortener
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/pkg/metadata"
+)
+
+// metadataFetchSlots bounds how many pages are fetched in the background
+// at once. Links created while every slot is taken wait for
+// FillMissingMetadata.
+const metadataFetchSlots = 4
+
+func newMetadataFetcher(cfg *config.Config) *metadata.Fetcher {
+	return metadata.NewFetcher(
+		newOutboundClient(cfg.METADATA_TIMEOUT),
+		"yaurl-metadata/1.0 (+"+cfg.APP_BASE_URL+")",
+		cfg.METADATA_MAX_BYTES,
+	)
+}
+
+// fetchMetadataLater refreshes the metadata of code in the background.
+func (s *service) fetchMetadataLater(code string) {
+	if !s.cfg.METADATA_FETCH_ON_CREATE {
+		return
+	}
+
+	select {
+	case s.metadataSlots <- struct{}{}:
+	default:
+		s.logger.Debug("Deferring metadata fetch, too many in flight", "code", code)
+		return
+	}
+
+	go func() {
+		defer func() { <-s.metadataSlots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*s.cfg.METADATA_TIMEOUT)
+		defer cancel()
+
+		var urlId int
+		row := s.db.QueryRowContext(ctx, "SELECT id FROM urls WHERE short_url = $1", code)
+		if err := row.Scan(&urlId); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return
+		}
+
+		s.refreshMetadata(ctx, urlId)
+	}()
+}
+
+// RefreshUrlMetadata fetches the destination page of code again. A page
+// that can't be read keeps the metadata fetched before, the link's
+// MetadataError tells what went wrong.
+func (s *service) RefreshUrlMetadata(ctx context.Context, code string, userId int) (*Link, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.refreshMetadata(ctx, urlId); err != nil {
+		return nil, err
+	}
+
+	return s.getLink(ctx, urlId)
+}
+
+// FillMissingMetadata fetches the metadata of links that have none yet,
+// because their fetch was deferred or their destination changed. Links
+// whose page can't be read count as fetched, their owner can retry.
+func (s *service) FillMissingMetadata(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id FROM urls
+		WHERE metadata_fetched_at IS NULL AND deleted_at IS NULL
+		ORDER BY id LIMIT $1`,
+		s.cfg.METADATA_BACKFILL_BATCH,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query urls without metadata", "error", err.Error())
+		return 0, ErrExecQuery
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return 0, ErrExecQuery
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return 0, ErrExecQuery
+	}
+
+	filled := 0
+	for _, id := range ids {
+		if err := s.refreshMetadata(ctx, id); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			return filled, err
+		}
+		filled++
+	}
+
+	if filled > 0 {
+		s.logger.Info("Missing metadata filled", "links", filled)
+	}
+
+	return filled, nil
+}
+
+func (s *service) refreshMetadata(ctx context.Context, urlId int) error {
+	var longUrl string
+	row := s.db.QueryRowContext(
+		ctx,
+		fmt.Sprintf("SELECT %s FROM urls u WHERE u.id = $1 AND u.deleted_at IS NULL", sqlCurrentLongUrl),
+		urlId,
+	)
+	if err := row.Scan(&longUrl); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	fetched, err := s.fetcher.Fetch(ctx, longUrl)
+	if err != nil {
+		reason := outboundError(err)
+		s.logger.Info("Failed to fetch destination metadata", "url_id", urlId, "error", reason)
+
+		_, err = s.db.ExecContext(
+			ctx,
+			"UPDATE urls SET metadata_error = $2, metadata_fetched_at = NOW() WHERE id = $1",
+			urlId,
+			reason,
+		)
+	} else {
+		_, err = s.db.ExecContext(
+			ctx,
+			"UPDATE urls SET metadata = $2, metadata_error = '', metadata_fetched_at = NOW() WHERE id = $1",
+			urlId,
+			LinkMetadata{Metadata: *fetched},
+		)
+	}
+	if err != nil {
+		s.logger.Error("Failed to store url metadata", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	return nil
+}
+
+// UnfurlUrl builds the card link preview crawlers are shown for code, from
+// the stored metadata of the destination and the owner's overrides. No
+// click is spent, so the card never carries the destination itself, only
+// what it looks like. Protected links don't even tell that.
+func (s *service) UnfurlUrl(ctx context.Context, code string) (*LinkCard, error) {
+	target, err := s.findRedirectTarget(ctx, code, redirectVisit{})
+	if err != nil {
+		return nil, err
+	}
+
+	var stored *LinkMetadata
+	var social *SocialCard
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT metadata, social FROM urls WHERE short_url = $1 AND deleted_at IS NULL",
+		code,
+	)
+	if err := row.Scan(&stored, &social); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return newLinkCard(s.cfg.APP_BASE_URL+"/"+code, target.passwordHash != nil, stored, social), nil
+}
+
+// newLinkCard fills the card of shortUrl from the page metadata and the
+// owner's overrides. The page of a protected link is left out.
+func newLinkCard(shortUrl string, protected bool, stored *LinkMetadata, social *SocialCard) *LinkCard {
+	card := &LinkCard{ShortUrl: shortUrl}
+
+	var page metadata.Metadata
+	if stored != nil && !protected {
+		page = stored.Metadata
+	}
+	if social == nil {
+		social = &SocialCard{}
+	}
+
+	card.Title = cmp.Or(social.Title, page.Title)
+	card.Description = cmp.Or(social.Description, page.Description)
+	card.Image = cmp.Or(social.Image, page.Image)
+
+	for property, content := range page.OpenGraph {
+		switch {
+		case property == "og:url", property == "og:title", property == "og:description", property == "og:image":
+			continue
+		case social.Image != "" && strings.HasPrefix(property, "og:image:"):
+			// Width, height and alt of the page's image don't fit the
+			// owner's one.
+			continue
+		}
+		card.Tags = append(card.Tags, CardTag{Property: property, Content: content})
+	}
+
+	if card.Title == "" {
+		card.Title = card.ShortUrl
+	}
+	card.Tags = append(card.Tags,
+		CardTag{Property: "og:title", Content: card.Title},
+		CardTag{Property: "og:url", Content: card.ShortUrl},
+	)
+	if _, ok := page.OpenGraph["og:site_name"]; !ok {
+		card.Tags = append(card.Tags, CardTag{Property: "og:site_name", Content: "YAURL"})
+	}
+	if card.Description != "" {
+		card.Tags = append(card.Tags, CardTag{Property: "og:description", Content: card.Description})
+	}
+	if card.Image != "" {
+		card.Tags = append(card.Tags, CardTag{Property: "og:image", Content: card.Image})
+	}
+
+	slices.SortFunc(card.Tags, func(a, b CardTag) int {
+		return strings.Compare(a.Property, b.Property)
+	})
+
+	return card
+}
@@ -0,0 +1,114 @@
+package shortener
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/badiwidya/yaurl/internal/pkg/metadata"
+)
+
+const cardShortUrl = "https://yaurl.example/abc"
+
+var cardPage = &LinkMetadata{Metadata: metadata.Metadata{
+	Title:       "Launch notes",
+	Description: "What shipped this week.",
+	Image:       "https://dest.example/cover.png",
+	OpenGraph: map[string]string{
+		"og:title":        "Launch notes",
+		"og:url":          "https://dest.example/secret/launch?token=1",
+		"og:type":         "article",
+		"og:image":        "/cover.png",
+		"og:image:width":  "1200",
+		"og:image:height": "630",
+	},
+}}
+
+func cardTags(card *LinkCard) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range card.Tags {
+		tags[tag.Property] = tag.Content
+	}
+
+	return tags
+}
+
+func TestNewLinkCard(t *testing.T) {
+	card := newLinkCard(cardShortUrl, false, cardPage, nil)
+
+	if card.Title != "Launch notes" || card.Description != "What shipped this week." || card.Image != "https://dest.example/cover.png" {
+		t.Errorf("card = %+v, want the page's title, description and image", card)
+	}
+
+	tags := cardTags(card)
+	want := map[string]string{
+		"og:title":        "Launch notes",
+		"og:description":  "What shipped this week.",
+		"og:image":        "https://dest.example/cover.png",
+		"og:image:width":  "1200",
+		"og:image:height": "630",
+		"og:type":         "article",
+		"og:url":          cardShortUrl,
+		"og:site_name":    "YAURL",
+	}
+	for property, content := range want {
+		if tags[property] != content {
+			t.Errorf("%s = %q, want %q", property, tags[property], content)
+		}
+	}
+	if len(tags) != len(want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+
+	if !slices.IsSortedFunc(card.Tags, func(a, b CardTag) int { return strings.Compare(a.Property, b.Property) }) {
+		t.Errorf("tags are not sorted: %v", card.Tags)
+	}
+}
+
+func TestNewLinkCardOverrides(t *testing.T) {
+	social := &SocialCard{Title: "Our launch", Image: "https://cdn.example/og.png"}
+	card := newLinkCard(cardShortUrl, false, cardPage, social)
+
+	if card.Title != "Our launch" || card.Image != "https://cdn.example/og.png" {
+		t.Errorf("card = %+v, want the owner's title and image", card)
+	}
+	if card.Description != "What shipped this week." {
+		t.Errorf("Description = %q, want the page's one where the owner set none", card.Description)
+	}
+
+	tags := cardTags(card)
+	if _, ok := tags["og:image:width"]; ok {
+		t.Errorf("og:image:width of the page's image kept for the owner's image")
+	}
+	if tags["og:image"] != "https://cdn.example/og.png" {
+		t.Errorf("og:image = %q, want the owner's image", tags["og:image"])
+	}
+}
+
+func TestNewLinkCardProtected(t *testing.T) {
+	card := newLinkCard(cardShortUrl, true, cardPage, nil)
+
+	if card.Title != cardShortUrl || card.Description != "" || card.Image != "" {
+		t.Errorf("card = %+v, want nothing from the page of a protected link", card)
+	}
+	if tags := cardTags(card); len(tags) != 3 || tags["og:type"] != "" {
+		t.Errorf("tags = %v, want only og:title, og:url and og:site_name", tags)
+	}
+
+	social := &SocialCard{Title: "Members only", Description: "Ask for the password."}
+	card = newLinkCard(cardShortUrl, true, cardPage, social)
+	if card.Title != "Members only" || card.Description != "Ask for the password." || card.Image != "" {
+		t.Errorf("card = %+v, want the owner's overrides only", card)
+	}
+}
+
+func TestNewLinkCardLeavesOutDestination(t *testing.T) {
+	for _, protected := range []bool{false, true} {
+		card := newLinkCard(cardShortUrl, protected, cardPage, nil)
+		for _, tag := range card.Tags {
+			if strings.Contains(tag.Content, "/secret/launch") {
+				t.Errorf("protected=%v: %s reveals the destination: %q", protected, tag.Property, tag.Content)
+			}
+		}
+	}
+}
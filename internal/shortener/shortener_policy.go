@@ -0,0 +1,372 @@
+package shortener
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+// Reasons a destination is turned down, reported as validation errors on
+// the field holding the URL.
+const (
+	policyInvalid    = "must be an absolute URL"
+	policyScheme     = "scheme must be one of %s"
+	policyBlocked    = "domain is blocked"
+	policyNotAllowed = "domain is not on the allowlist"
+	policySelf       = "must not point back at this shortener"
+	policyShortener  = "must not point at another URL shortener"
+	policyPrivate    = "must not point at a private or loopback address"
+)
+
+// knownShorteners are refused as destinations out of the box, chains of
+// shorteners hide where a link really goes. The domains file can add more.
+var knownShorteners = []string{
+	"bit.ly", "bitly.com", "tinyurl.com", "t.co", "goo.gl", "ow.ly", "is.gd",
+	"v.gd", "buff.ly", "rebrand.ly", "cutt.ly", "shorturl.at", "tiny.cc",
+	"rb.gy", "bl.ink", "t.ly", "s.id", "lnkd.in", "trib.al",
+}
+
+// Policy decides which destinations links may point at. The domains file
+// is read again on Reload, a failed reload keeps the previous lists.
+type Policy struct {
+	cfg    *config.Config
+	logger *slog.Logger
+
+	schemes  []string
+	selfHost string
+
+	mu    sync.RWMutex
+	lists domainLists
+}
+
+// domainLists holds the directives of the domains file, one per line:
+//
+//	block example.com
+//	allow example.org
+//	shortener sho.rt
+//
+// Every entry also covers subdomains. Once any domain is allowed, only
+// allowed domains are accepted.
+type domainLists struct {
+	blocked    map[string]struct{}
+	allowed    map[string]struct{}
+	shorteners map[string]struct{}
+}
+
+// PolicySummary counts the entries of the loaded domains file.
+type PolicySummary struct {
+	File       string `json:"file,omitempty"`
+	Blocked    int    `json:"blocked"`
+	Allowed    int    `json:"allowed"`
+	Shorteners int    `json:"shorteners"`
+}
+
+func NewPolicy(cfg *config.Config, logger *slog.Logger) (*Policy, error) {
+	p := &Policy{
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	for scheme := range strings.SplitSeq(cfg.DESTINATION_SCHEMES, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			p.schemes = append(p.schemes, scheme)
+		}
+	}
+	if len(p.schemes) == 0 {
+		p.schemes = []string{"http", "https"}
+	}
+
+	if base, err := url.Parse(cfg.APP_BASE_URL); err == nil {
+		p.selfHost = normalizeDomain(base.Hostname())
+	}
+
+	if _, err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Reload reads DESTINATION_DOMAINS_FILE again.
+func (p *Policy) Reload() (PolicySummary, error) {
+	lists := domainLists{
+		blocked:    make(map[string]struct{}),
+		allowed:    make(map[string]struct{}),
+		shorteners: make(map[string]struct{}),
+	}
+	for _, domain := range knownShorteners {
+		lists.shorteners[domain] = struct{}{}
+	}
+
+	if path := p.cfg.DESTINATION_DOMAINS_FILE; path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return PolicySummary{}, err
+		}
+		defer f.Close()
+
+		if err := lists.parse(f); err != nil {
+			return PolicySummary{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.lists = lists
+	p.mu.Unlock()
+
+	summary := PolicySummary{
+		File:       p.cfg.DESTINATION_DOMAINS_FILE,
+		Blocked:    len(lists.blocked),
+		Allowed:    len(lists.allowed),
+		Shorteners: len(lists.shorteners),
+	}
+	p.logger.Info("Destination policy loaded", "file", summary.File, "blocked", summary.Blocked,
+		"allowed", summary.Allowed, "shorteners", summary.Shorteners)
+
+	return summary, nil
+}
+
+func (l domainLists) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: want a directive and a domain", line)
+		}
+
+		domain := normalizeDomain(fields[1])
+		if msg := validateDomain(domain); msg != "" {
+			return fmt.Errorf("line %d: domain %s", line, msg)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "block":
+			l.blocked[domain] = struct{}{}
+		case "allow":
+			l.allowed[domain] = struct{}{}
+		case "shortener":
+			l.shorteners[domain] = struct{}{}
+		default:
+			return fmt.Errorf("line %d: unknown directive %q", line, fields[0])
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Check returns why rawUrl may not be used as a destination, or an empty
+// string when it may.
+func (p *Policy) Check(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Scheme == "" {
+		return policyInvalid
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if !slices.Contains(p.schemes, scheme) {
+		return fmt.Sprintf(policyScheme, strings.Join(p.schemes, ", "))
+	}
+
+	host := normalizeDomain(parsed.Hostname())
+	if host == "" {
+		return policyInvalid
+	}
+
+	if addr, ok := parseHostAddr(host); ok {
+		if !publicAddr(addr) {
+			return policyPrivate
+		}
+	} else if endsInNumber(host) {
+		// Browsers read hosts like 2130706433 or 0x7f.1 as IPv4 addresses,
+		// anything that looks like one but didn't parse is refused too.
+		return policyInvalid
+	} else if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return policyPrivate
+	}
+
+	if p.selfHost != "" && host == p.selfHost {
+		return policySelf
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if matchDomain(p.lists.blocked, host) {
+		return policyBlocked
+	}
+	if len(p.lists.allowed) > 0 && !matchDomain(p.lists.allowed, host) {
+		return policyNotAllowed
+	}
+	if matchDomain(p.lists.shorteners, host) {
+		return policyShortener
+	}
+
+	return ""
+}
+
+// matchDomain reports whether host or one of its parent domains is in
+// domains.
+func matchDomain(domains map[string]struct{}, host string) bool {
+	for {
+		if _, ok := domains[host]; ok {
+			return true
+		}
+
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return false
+		}
+		host = parent
+	}
+}
+
+// parseHostAddr parses an IP literal, including the shorthand IPv4 forms
+// browsers accept such as 127.1, 0x7f000001 or 0177.0.0.1.
+func parseHostAddr(host string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap(), true
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	var numbers []uint64
+	for _, part := range parts {
+		n, err := parseIPv4Number(part)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		numbers = append(numbers, n)
+	}
+
+	last := len(numbers) - 1
+	for _, n := range numbers[:last] {
+		if n > 255 {
+			return netip.Addr{}, false
+		}
+	}
+	if numbers[last] >= 1<<(8*(5-len(numbers))) {
+		return netip.Addr{}, false
+	}
+
+	ipv4 := numbers[last]
+	for i, n := range numbers[:last] {
+		ipv4 |= n << (8 * (3 - i))
+	}
+
+	return netip.AddrFrom4([4]byte{byte(ipv4 >> 24), byte(ipv4 >> 16), byte(ipv4 >> 8), byte(ipv4)}), true
+}
+
+var errNotIPv4Number = errors.New("not an IPv4 number")
+
+func parseIPv4Number(part string) (uint64, error) {
+	if part == "" {
+		return 0, errNotIPv4Number
+	}
+
+	base := 10
+	switch {
+	case len(part) > 1 && (strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X")):
+		part, base = part[2:], 16
+		if part == "" {
+			return 0, nil
+		}
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+
+	return strconv.ParseUint(part, base, 32)
+}
+
+// endsInNumber follows the URL standard's check for hosts that must be
+// parsed as IPv4.
+func endsInNumber(host string) bool {
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+	last := parts[len(parts)-1]
+	if last == "" {
+		return false
+	}
+
+	if strings.Trim(last, "0123456789") == "" {
+		return true
+	}
+
+	_, err := parseIPv4Number(last)
+
+	return err == nil && (strings.HasPrefix(last, "0x") || strings.HasPrefix(last, "0X"))
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, not covered by
+// IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func publicAddr(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+var errPrivateDestination = errors.New("destination resolves to a private or loopback address")
+
+// newOutboundClient returns the client used to fetch destinations. Names
+// are resolved when dialing, so the check on private addresses is repeated
+// there: a public name may still point inside the network.
+func newOutboundClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(addr.Unmap()) {
+				return errPrivateDestination
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed in place of the destination.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// outboundError describes a failed outbound request without repeating the
+// method and URL.
+func outboundError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	return err.Error()
+}
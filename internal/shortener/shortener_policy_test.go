@@ -0,0 +1,262 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/badiwidya/yaurl/internal/config"
+)
+
+func newTestPolicy(t *testing.T, domains string) *Policy {
+	t.Helper()
+
+	cfg := &config.Config{APP_BASE_URL: "https://yaurl.io"}
+	if domains != "" {
+		cfg.DESTINATION_DOMAINS_FILE = filepath.Join(t.TempDir(), "domains")
+		if err := os.WriteFile(cfg.DESTINATION_DOMAINS_FILE, []byte(domains), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewPolicy(cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+
+	return p
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := newTestPolicy(t, "")
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/page", ""},
+		{"http://8.8.8.8/", ""},
+		{"http://[2606:4700:4700::1111]/", ""},
+		{"example.com", policyInvalid},
+		{"/relative/path", policyInvalid},
+		{"ftp://example.com/file", fmt.Sprintf(policyScheme, "http, https")},
+		{"javascript:alert(1)", fmt.Sprintf(policyScheme, "http, https")},
+
+		{"http://127.0.0.1/", policyPrivate},
+		{"http://127.1/", policyPrivate},
+		{"http://0x7f000001/", policyPrivate},
+		{"http://0177.0.0.1/", policyPrivate},
+		{"http://2130706433/", policyPrivate},
+		{"http://0x7f.1/", policyPrivate},
+		{"http://10.0.0.1/", policyPrivate},
+		{"http://192.168.1.1:8080/", policyPrivate},
+		{"http://169.254.169.254/latest/meta-data/", policyPrivate},
+		{"http://100.64.0.1/", policyPrivate},
+		{"http://0.0.0.0/", policyPrivate},
+		{"http://[::1]/", policyPrivate},
+		{"http://[::ffff:127.0.0.1]/", policyPrivate},
+		{"http://[fd00::1]/", policyPrivate},
+		{"http://localhost/", policyPrivate},
+		{"http://localhost./", policyPrivate},
+		{"http://LOCALHOST:3000/", policyPrivate},
+		{"http://app.localhost/", policyPrivate},
+
+		// Hosts a browser would read as IPv4 but that aren't valid.
+		{"http://1.2.3.256/", policyInvalid},
+		{"http://example.0x10/", policyInvalid},
+		{"http://4294967296/", policyInvalid},
+
+		{"https://yaurl.io/Ab3", policySelf},
+		{"https://YAURL.io./Ab3", policySelf},
+		{"https://bit.ly/abc", policyShortener},
+		{"https://www.bit.ly/abc", policyShortener},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := p.Check(tt.url); got != tt.want {
+				t.Errorf("Check(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckDomainLists(t *testing.T) {
+	p := newTestPolicy(t, `
+# both blocked and allowed, the block wins
+block evil.com
+allow evil.com
+
+allow good.com
+allow bit.ly       # allowed but still a shortener
+shortener sho.rt   # a shortener that isn't allowed
+`)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://good.com/", ""},
+		{"https://www.good.com/", ""},
+		{"https://evil.com/", policyBlocked},
+		{"https://cdn.evil.com/", policyBlocked},
+		{"https://example.com/", policyNotAllowed},
+		{"https://notgood.com/", policyNotAllowed},
+		{"https://sho.rt/x", policyNotAllowed},
+		{"https://bit.ly/x", policyShortener},
+		// Address and self checks come before the lists.
+		{"http://127.0.0.1/", policyPrivate},
+		{"https://yaurl.io/x", policySelf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := p.Check(tt.url); got != tt.want {
+				t.Errorf("Check(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckShortenerDirective(t *testing.T) {
+	p := newTestPolicy(t, "block bad.example\nshortener sho.rt\n")
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/", ""},
+		{"https://bad.example/", policyBlocked},
+		{"https://sho.rt/x", policyShortener},
+		{"https://go.sho.rt/x", policyShortener},
+	}
+
+	for _, tt := range tests {
+		if got := p.Check(tt.url); got != tt.want {
+			t.Errorf("Check(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyReloadInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains string
+		want    string
+	}{
+		{"missing domain", "block\n", "line 1: want a directive and a domain"},
+		{"unknown directive", "\n# comment\npermit example.com\n", `line 3: unknown directive "permit"`},
+		{"bad domain", "block exa_mple\n", "line 1: domain must be a domain name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "domains")
+			if err := os.WriteFile(path, []byte(tt.domains), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{DESTINATION_DOMAINS_FILE: path}
+			_, err := NewPolicy(cfg, testLogger)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewPolicy error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHostAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1"},
+		{"127.1", "127.0.0.1"},
+		{"127.0.1", "127.0.0.1"},
+		{"0x7f000001", "127.0.0.1"},
+		{"0X7F.0.0.1", "127.0.0.1"},
+		{"0177.0.0.1", "127.0.0.1"},
+		{"2130706433", "127.0.0.1"},
+		{"0x", "0.0.0.0"},
+		{"::1", "::1"},
+		{"::ffff:127.0.0.1", "127.0.0.1"},
+		{"1.2.3.256", ""},
+		{"256.1", ""},
+		{"1.2.3.4.5", ""},
+		{"4294967296", ""},
+		{"08.0.0.1", ""},
+		{"1..1", ""},
+		{"example.com", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			addr, ok := parseHostAddr(tt.host)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("parseHostAddr(%q) = %s, want no address", tt.host, addr)
+				}
+				return
+			}
+			if !ok || addr != netip.MustParseAddr(tt.want) {
+				t.Errorf("parseHostAddr(%q) = %s, %v, want %s", tt.host, addr, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndsInNumber(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", false},
+		{"example.com.", false},
+		{"1.2.3.4", true},
+		{"example.123", true},
+		{"example.123.", true},
+		{"example.0x1f", true},
+		{"example.0x", true},
+		{"example.0xg", false},
+		{"123.example", false},
+		{"x1", false},
+	}
+
+	for _, tt := range tests {
+		if got := endsInNumber(tt.host); got != tt.want {
+			t.Errorf("endsInNumber(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestOutboundClientRefusesLoopback(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	client := newOutboundClient(time.Second)
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request to a loopback server succeeded")
+	}
+	if !errors.Is(err, errPrivateDestination) {
+		t.Errorf("error = %v, want %v", err, errPrivateDestination)
+	}
+	if got := outboundError(err); !strings.Contains(got, errPrivateDestination.Error()) || strings.Contains(got, srv.URL) {
+		t.Errorf("outboundError = %q", got)
+	}
+	if hits != 0 {
+		t.Errorf("server was hit %d times", hits)
+	}
+}
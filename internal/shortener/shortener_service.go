@@ -1,52 +1,330 @@
 package shortener
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
-	"math/rand"
+	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/badiwidya/yaurl/internal/auth"
 	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/pkg/metadata"
+	"github.com/badiwidya/yaurl/internal/pkg/reputation"
+	"github.com/badiwidya/yaurl/internal/pkg/types"
+	"github.com/badiwidya/yaurl/internal/pkg/useragent"
 )
 
-func NewService(config *config.Config, logger *slog.Logger, db *sql.DB) *service {
-	return &service{
-		cfg:    config,
-		logger: logger,
-		db:     db,
-		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+func NewService(config *config.Config, logger *slog.Logger, db *sql.DB, policy *Policy, reputation *reputation.Store) (*service, error) {
+	codeGen, err := newCodeGenerator(config, logger, db)
+	if err != nil {
+		return nil, err
 	}
+
+	return &service{
+		cfg:        config,
+		logger:     logger,
+		db:         db,
+		policy:     policy,
+		reputation: reputation,
+		codeGen:    codeGen,
+		prober:     newHealthProber(config),
+
+		fetcher:       newMetadataFetcher(config),
+		metadataSlots: make(chan struct{}, metadataFetchSlots),
+	}, nil
 }
 
 type Service interface {
-	CreateNewShortUrl(context.Context, string, int, *time.Time) (*string, error)
-	FindLongUrl(context.Context, string) (*string, error)
+	CreateNewShortUrl(context.Context, URL, int) (*string, error)
+	FindLongUrl(context.Context, string, bool) (*Destination, error)
+	FindShortUrl(context.Context, string) (*string, error)
+	PreviewUrl(context.Context, string, Visitor) (*LinkPreview, error)
+	UnlockUrl(context.Context, string, string) (*Destination, error)
+	IsProtected(context.Context, string) (bool, error)
+	GetUrlStats(context.Context, string, int, StatsQuery) (*UrlStats, error)
+	ListUrls(context.Context, int, ListQuery) (*LinkPage, error)
+	UpdateUrl(context.Context, string, int, UpdateURL) (*Link, error)
+	GetUrlHistory(context.Context, string, int) ([]HistoryEntry, error)
+	RollbackUrl(context.Context, string, int, int) (*Link, error)
+	GetUrlSchedule(context.Context, string, int) ([]ScheduleEntry, error)
+	UpdateUrlSchedule(context.Context, string, int, []ScheduleEntry) ([]ScheduleEntry, error)
+	SetUrlDisabled(context.Context, string, int, bool) (*Link, error)
+	DeleteUrl(context.Context, string, int) error
+	RestoreUrl(context.Context, string, int) (*Link, error)
+	PurgeTrash(context.Context) (int64, error)
+	CreateBulkShortUrls(context.Context, []BulkRow, int, string) (*BulkReport, error)
+	ExportUrls(context.Context, int, func(Link) error) error
+	ExportClicks(context.Context, int, func(ExportedClick) error) error
+	ImportUrls(context.Context, int, []ImportedLink) (*ImportReport, error)
+	ListPreviewDomains(context.Context) ([]PreviewDomain, error)
+	AddPreviewDomain(context.Context, string, int) (*PreviewDomain, error)
+	RemovePreviewDomain(context.Context, string) error
+	ReloadDestinationPolicy() (PolicySummary, error)
+	RecheckReputation(context.Context) (int, error)
+	ListFlaggedUrls(context.Context) ([]FlaggedLink, error)
+	ClearUrlFlag(context.Context, string) error
+	CheckLinkHealth(context.Context) (int, error)
+	GetUrlHealth(context.Context, string, int) (*LinkHealth, error)
+	ListUrlEvents(context.Context, int, int64) ([]LinkEvent, error)
+	RefreshUrlMetadata(context.Context, string, int) (*Link, error)
+	FillMissingMetadata(context.Context) (int, error)
+	UnfurlUrl(context.Context, string) (*LinkCard, error)
 }
 
 type service struct {
-	cfg    *config.Config
-	logger *slog.Logger
-	db     *sql.DB
-	rand   *rand.Rand
+	cfg        *config.Config
+	logger     *slog.Logger
+	db         *sql.DB
+	policy     *Policy
+	reputation *reputation.Store
+	codeGen    CodeGenerator
+	prober     *healthProber
+
+	fetcher       *metadata.Fetcher
+	metadataSlots chan struct{}
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
+// maxCodeAttempts bounds how many generated codes are tried before
+// CreateNewShortUrl gives up on a collision streak.
+const maxCodeAttempts = 5
+
 var ErrNotValidUrl error = errors.New("Invalid URL")
 var ErrExecQuery error = errors.New("Error when executing query")
 var ErrNotFound error = errors.New("Row not found")
+var ErrExpired error = errors.New("Short URL has expired")
+var ErrDisabled error = errors.New("Short URL is disabled")
+var ErrForbidden error = errors.New("Short URL belongs to another user")
+var ErrHistoryNotFound error = errors.New("History entry not found")
+var ErrAliasTaken error = errors.New("Alias already taken")
+var ErrPasswordRequired error = errors.New("Short URL is password protected")
+var ErrWrongPassword error = errors.New("Wrong password")
+var ErrExhausted error = errors.New("Short URL has reached its click limit")
+var ErrPreviewRequired error = errors.New("Short URL must be previewed first")
+var ErrDomainExists error = errors.New("Domain already listed")
+var ErrNotActive error = errors.New("Short URL is not active yet")
+var ErrActiveAfterExpiry error = errors.New("Short URL would expire before it becomes active")
+var ErrTooManyRows error = errors.New("Too many rows in bulk request")
+var ErrCodeExhausted error = errors.New("Could not generate a free short code")
+var ErrFlagged error = errors.New("Short URL was flagged as unsafe")
+
+// FindLongUrl resolves code for a visitor and spends a click on it. Links
+// that show an interstitial first report ErrPreviewRequired until the
+// visitor has confirmed it.
+func (s *service) FindLongUrl(ctx context.Context, code string, confirmed bool) (*Destination, error) {
+	target, err := s.findRedirectTarget(ctx, code, redirectVisit{spend: true, confirmed: confirmed})
+	if err != nil {
+		return nil, err
+	}
+
+	if target.passwordHash != nil {
+		return nil, ErrPasswordRequired
+	}
+
+	if target.preview && !confirmed {
+		return nil, ErrPreviewRequired
+	}
+
+	return &target.destination, nil
+}
+
+// FindShortUrl returns the public short URL of code, as long as the link
+// has not been deleted. Disabled or expired links are still found.
+func (s *service) FindShortUrl(ctx context.Context, code string) (*string, error) {
+	var exists bool
 
-func (s *service) FindLongUrl(ctx context.Context, code string) (*string, error) {
 	row := s.db.QueryRowContext(
 		ctx,
-		"SELECT long_url FROM urls WHERE short_url = $1",
+		"SELECT EXISTS (SELECT 1 FROM urls WHERE short_url = $1 AND deleted_at IS NULL)",
 		code,
 	)
+	if err := row.Scan(&exists); err != nil {
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	shortUrl := s.cfg.APP_BASE_URL + "/" + code
+
+	return &shortUrl, nil
+}
+
+// IsProtected reports whether code asks visitors for a password. Links
+// that can't be visited at all report why instead.
+func (s *service) IsProtected(ctx context.Context, code string) (bool, error) {
+	target, err := s.findRedirectTarget(ctx, code, redirectVisit{})
+	if err != nil {
+		return false, err
+	}
+
+	return target.passwordHash != nil, nil
+}
+
+// UnlockUrl returns the destination of a password protected link once
+// password matches the stored hash. Typing the password counts as
+// confirming the interstitial, the visitor deliberately asked for the link.
+func (s *service) UnlockUrl(ctx context.Context, code string, password string) (*Destination, error) {
+	target, err := s.findRedirectTarget(ctx, code, redirectVisit{})
+	if err != nil {
+		return nil, err
+	}
+
+	if target.passwordHash != nil {
+		ok, err := auth.ComparePassword(password, *target.passwordHash)
+		if err != nil {
+			s.logger.Error("Failed to compare link password", "code", code, "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		if !ok {
+			return nil, ErrWrongPassword
+		}
+	}
+
+	// Only now that the password is known to match may a click be spent.
+	target, err = s.findRedirectTarget(ctx, code, redirectVisit{spend: true, unlocked: true, confirmed: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &target.destination, nil
+}
+
+// PreviewUrl describes where code leads visitor without following it. The
+// destination of password protected links is never revealed.
+func (s *service) PreviewUrl(ctx context.Context, code string, visitor Visitor) (*LinkPreview, error) {
+	target, err := s.findRedirectTarget(ctx, code, redirectVisit{})
+	if err != nil {
+		return nil, err
+	}
+
+	if target.passwordHash != nil {
+		return nil, ErrPasswordRequired
+	}
+
+	destination := target.destination.Route(visitor)
+
+	preview := &LinkPreview{
+		Code:      code,
+		ShortUrl:  s.cfg.APP_BASE_URL + "/" + code,
+		LongUrl:   destination.LongUrl,
+		OwnerName: target.ownerName,
+		CreatedAt: target.createdAt,
+	}
+	if parsed, err := url.Parse(destination.LongUrl); err == nil {
+		preview.Domain = parsed.Hostname()
+	}
+
+	return preview, nil
+}
+
+type redirectTarget struct {
+	destination  Destination
+	passwordHash *string
+	preview      bool
+	ownerName    string
+	createdAt    time.Time
+}
+
+// redirectVisit lists the gates a visit has passed. A click is only spent
+// when spend is set and the visit got through every gate the link has.
+type redirectVisit struct {
+	spend     bool
+	unlocked  bool
+	confirmed bool
+}
+
+// sqlPreviewDomainMatch is true when the host of a URL column equals a
+// forced preview domain or is one of its subdomains.
+const sqlPreviewDomainMatch = `EXISTS (
+	SELECT 1 FROM preview_domains d
+	WHERE %[1]s = d.domain OR right(%[1]s, length(d.domain) + 1) = '.' || d.domain
+)`
+
+// findRedirectTarget looks code up, resolving the scheduled destination for
+// the current time, and in the same statement spends one of its remaining
+// clicks when the link is click limited and the visit may follow it.
+// Postgres re-checks remaining_clicks > 0 on the latest row version, so
+// concurrent redirects can never take the counter below zero. A forced
+// preview domain behind any redirect rule or variant puts the whole link behind the
+// preview, the visitor isn't known here.
+func (s *service) findRedirectTarget(ctx context.Context, code string, visit redirectVisit) (*redirectTarget, error) {
+	row := s.db.QueryRowContext(
+		ctx,
+		fmt.Sprintf(
+			`WITH resolved AS (
+				SELECT u.id,
+					COALESCE(
+						(SELECT s.long_url FROM url_schedule s
+						WHERE s.url_id = u.id AND s.starts_at <= NOW()
+						ORDER BY s.starts_at DESC LIMIT 1),
+						u.long_url
+					) AS long_url,
+					u.expires_at, u.active_from, u.disabled_at IS NOT NULL AS disabled,
+					u.flagged_at IS NOT NULL AS flagged, u.password_hash,
+					u.remaining_clicks, u.force_preview, u.forward_query, u.forward_path, u.rules, u.variants, u.redirect_status, u.created_at,
+					u.fallback_url, u.health_status, u.health_url,
+					COALESCE(NULLIF(o.name, ''), o.username, '') AS owner_name
+				FROM urls u LEFT JOIN users o ON o.id = u.user_id
+				WHERE u.short_url = $1 AND u.deleted_at IS NULL
+			), link AS (
+				SELECT resolved.*, force_preview OR %s
+					OR EXISTS (SELECT 1 FROM jsonb_array_elements(resolved.rules || resolved.variants) r WHERE %s)
+					OR (fallback_url IS NOT NULL AND %s) AS preview,
+					fallback_url IS NOT NULL AND health_status = 'failing' AND health_url = long_url AS failing
+				FROM resolved
+			), spent AS (
+				UPDATE urls u SET remaining_clicks = u.remaining_clicks - 1
+				FROM link
+				WHERE u.id = link.id
+					AND $2
+					AND u.remaining_clicks > 0
+					AND NOT link.disabled
+					AND link.expires_at > NOW()
+					AND (link.active_from IS NULL OR link.active_from <= NOW())
+					AND ($3 OR link.password_hash IS NULL)
+					AND ($4 OR NOT link.preview)
+				RETURNING u.id
+			)
+			SELECT long_url, forward_query, forward_path, rules, variants, redirect_status, expires_at, active_from, disabled, flagged, password_hash,
+				remaining_clicks, preview, fallback_url, failing, created_at, owner_name, EXISTS (SELECT 1 FROM spent)
+			FROM link`,
+			fmt.Sprintf(sqlPreviewDomainMatch, fmt.Sprintf(sqlUrlHost, "resolved.long_url")),
+			fmt.Sprintf(sqlPreviewDomainMatch, fmt.Sprintf(sqlUrlHost, "(r.value->>'url')")),
+			fmt.Sprintf(sqlPreviewDomainMatch, fmt.Sprintf(sqlUrlHost, "resolved.fallback_url")),
+		),
+		code, visit.spend, visit.unlocked, visit.confirmed,
+	)
 
-	var long_url string
+	var target redirectTarget
+	var expires_at time.Time
+	var active_from *time.Time
+	var disabled, flagged, failing, spent bool
+	var remaining, redirectStatus *int
+	var fallbackUrl *string
 
-	err := row.Scan(&long_url)
+	err := row.Scan(
+		&target.destination.LongUrl, &target.destination.ForwardQuery, &target.destination.ForwardPath,
+		&target.destination.Rules, &target.destination.Variants, &redirectStatus, &expires_at, &active_from, &disabled, &flagged, &target.passwordHash, &remaining, &target.preview,
+		&fallbackUrl, &failing, &target.createdAt, &target.ownerName, &spent,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -55,53 +333,1768 @@ func (s *service) FindLongUrl(ctx context.Context, code string) (*string, error)
 		return nil, ErrExecQuery
 	}
 
-	return &long_url, nil
+	if flagged {
+		return nil, ErrFlagged
+	}
+
+	if disabled {
+		return nil, ErrDisabled
+	}
+
+	now := time.Now()
+
+	if active_from != nil && active_from.After(now) {
+		return nil, ErrNotActive
+	}
+
+	if !expires_at.After(now) {
+		return nil, ErrExpired
+	}
+
+	if remaining != nil {
+		// A visit that should have spent a click but didn't lost the race
+		// for the last one.
+		mustSpend := visit.spend &&
+			(visit.unlocked || target.passwordHash == nil) &&
+			(visit.confirmed || !target.preview)
+		if *remaining <= 0 || (mustSpend && !spent) {
+			return nil, ErrExhausted
+		}
+	}
+
+	target.destination.Status = s.redirectStatus(redirectStatus)
+	target.destination.CacheMaxAge = s.cfg.REDIRECT_CACHE_MAX_AGE
+
+	if failing {
+		// The primary comes back once it passes a check again, so the
+		// fallback is never handed out as a permanent redirect.
+		target.destination.LongUrl = *fallbackUrl
+		target.destination.Fallback = true
+		switch target.destination.Status {
+		case http.StatusMovedPermanently:
+			target.destination.Status = http.StatusFound
+		case http.StatusPermanentRedirect:
+			target.destination.Status = http.StatusTemporaryRedirect
+		}
+	}
+
+	return &target, nil
+}
+
+// redirectStatus returns the status a link redirects with, the instance
+// default unless the link chose its own.
+func (s *service) redirectStatus(linkStatus *int) int {
+	if linkStatus != nil && validRedirectStatus(*linkStatus) {
+		return *linkStatus
+	}
+
+	if validRedirectStatus(s.cfg.REDIRECT_STATUS) {
+		return s.cfg.REDIRECT_STATUS
+	}
+
+	return http.StatusFound
+}
+
+func (s *service) CreateNewShortUrl(ctx context.Context, newUrl URL, userId int) (*string, error) {
+	shortCode, err := s.createUrl(ctx, s.db, newUrl, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fetchMetadataLater(shortCode)
+
+	newURL := s.cfg.APP_BASE_URL + "/" + shortCode
+
+	return &newURL, nil
+}
+
+// createUrl inserts newUrl through q and returns its short code, retrying
+// with a fresh code whenever a generated one is already taken.
+func (s *service) createUrl(ctx context.Context, q dbtx, newUrl URL, userId int) (string, error) {
+	longUrl, expire := newUrl.Url, newUrl.Expires
+
+	var fallbackUrl *string
+	if newUrl.FallbackUrl != "" {
+		fallbackUrl = &newUrl.FallbackUrl
+	}
+
+	if err := s.checkDestinations(&longUrl, fallbackUrl, newUrl.Rules, newUrl.Variants); err != nil {
+		return "", err
+	}
+
+	var passwordHash *string
+	if newUrl.Password != "" {
+		hash, err := auth.HashPassword(newUrl.Password)
+		if err != nil {
+			s.logger.Error("Failed to hash link password", "error", err.Error())
+			return "", ErrExecQuery
+		}
+		passwordHash = &hash
+	}
+
+	if newUrl.Alias != "" {
+		inserted, err := s.insertURL(ctx, q, urlRow{
+			UserId:       userId,
+			ShortCode:    newUrl.Alias,
+			LongUrl:      longUrl,
+			ExpiresAt:    expire,
+			ActiveFrom:   newUrl.ActiveFrom,
+			PasswordHash: passwordHash,
+			MaxClicks:    newUrl.MaxClicks,
+			ForcePreview: newUrl.Preview,
+			ForwardQuery: newUrl.ForwardQuery,
+			ForwardPath:  newUrl.ForwardPath,
+			Rules:        newUrl.Rules,
+			Variants:     newUrl.Variants,
+			Status:       newUrl.RedirectStatus,
+			FallbackUrl:  fallbackUrl,
+			Social:       newUrl.Social,
+		})
+		if err != nil {
+			return "", err
+		}
+		if !inserted {
+			return "", ErrAliasTaken
+		}
+
+		return newUrl.Alias, nil
+	}
+
+	for range maxCodeAttempts {
+		shortCode, err := s.codeGen.Generate(ctx)
+		if err != nil {
+			s.logger.Error("Failed to generate short code", "error", err.Error())
+			return "", ErrExecQuery
+		}
+		if _, ok := reservedCodes[strings.ToLower(shortCode)]; ok {
+			s.logger.Warn("Generated a reserved short code, retrying", "code", shortCode)
+			continue
+		}
+
+		inserted, err := s.insertURL(ctx, q, urlRow{
+			UserId:       userId,
+			ShortCode:    shortCode,
+			LongUrl:      longUrl,
+			ExpiresAt:    expire,
+			ActiveFrom:   newUrl.ActiveFrom,
+			PasswordHash: passwordHash,
+			MaxClicks:    newUrl.MaxClicks,
+			ForcePreview: newUrl.Preview,
+			ForwardQuery: newUrl.ForwardQuery,
+			ForwardPath:  newUrl.ForwardPath,
+			Rules:        newUrl.Rules,
+			Variants:     newUrl.Variants,
+			Status:       newUrl.RedirectStatus,
+			FallbackUrl:  fallbackUrl,
+			Social:       newUrl.Social,
+		})
+		if err != nil {
+			return "", err
+		}
+		if !inserted {
+			s.logger.Warn("Short code collision, retrying", "code", shortCode)
+			continue
+		}
+
+		return shortCode, nil
+	}
+
+	s.logger.Error("Failed to find a free short code", "attempts", maxCodeAttempts)
+	return "", ErrCodeExhausted
+}
+
+// checkDestination returns why rawUrl can't be a destination, per the
+// destination policy or the reputation lists.
+func (s *service) checkDestination(rawUrl string) string {
+	if reason := s.policy.Check(rawUrl); reason != "" {
+		return reason
+	}
+
+	if match, ok := s.reputation.Check(rawUrl); ok {
+		return "is flagged as unsafe: " + match.ThreatType
+	}
+
+	return ""
 }
 
-func (s *service) CreateNewShortUrl(ctx context.Context, longUrl string, userId int, expire *time.Time) (*string, error) {
+// checkDestinations runs every URL a link can send visitors to through
+// checkDestination, returning the refusals as validation errors.
+func (s *service) checkDestinations(longUrl, fallbackUrl *string, rules RedirectRules, variants Variants) error {
+	errs := make(types.ValidationErrors)
+
+	if longUrl != nil {
+		if reason := s.checkDestination(*longUrl); reason != "" {
+			errs["url"] = reason
+		}
+	}
+	if fallbackUrl != nil && *fallbackUrl != "" {
+		if reason := s.checkDestination(*fallbackUrl); reason != "" {
+			errs["fallback_url"] = reason
+		}
+	}
+	for i, rule := range rules {
+		if reason := s.checkDestination(rule.Url); reason != "" {
+			errs[fmt.Sprintf("rules[%d].url", i)] = reason
+		}
+	}
+	for i, variant := range variants {
+		if reason := s.checkDestination(variant.Url); reason != "" {
+			errs[fmt.Sprintf("variants[%d].url", i)] = reason
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
 
+func validateLongUrl(longUrl string) error {
 	result, err := url.Parse(longUrl)
 	if err != nil || result.Scheme == "" || result.Host == "" {
-		return nil, ErrNotValidUrl
+		return ErrNotValidUrl
 	}
 
-	shortCode := s.generateRandomCode()
+	return nil
+}
 
-	if expire != nil {
-		_, err = s.db.ExecContext(
-			ctx,
-			"INSERT INTO urls (user_id, long_url, short_url, expires_at) VALUES ($1, $2, $3, $4)",
-			userId,
-			longUrl,
-			shortCode,
-			expire,
-		)
-	} else {
-		_, err = s.db.ExecContext(
-			ctx,
-			"INSERT INTO urls (user_id, long_url, short_url) VALUES ($1, $2, $3)",
-			userId,
-			longUrl,
-			shortCode,
-		)
+// urlRow is a new row of the urls table, nil optional columns fall back to
+// their database defaults.
+type urlRow struct {
+	UserId       int
+	ShortCode    string
+	LongUrl      string
+	ExpiresAt    *time.Time
+	ActiveFrom   *time.Time
+	CreatedAt    *time.Time
+	PasswordHash *string
+	MaxClicks    *int
+	ForcePreview bool
+	ForwardQuery string
+	ForwardPath  bool
+	Rules        RedirectRules
+	Variants     Variants
+	Status       int
+	FallbackUrl  *string
+	Social       *SocialCard
+}
+
+// insertURL reports false when the short code is already used by another
+// row.
+func (s *service) insertURL(ctx context.Context, q dbtx, newRow urlRow) (bool, error) {
+	columns := []string{"user_id", "long_url", "short_url"}
+	values := []any{newRow.UserId, newRow.LongUrl, newRow.ShortCode}
+
+	if newRow.ExpiresAt != nil {
+		columns = append(columns, "expires_at")
+		values = append(values, *newRow.ExpiresAt)
 	}
-	if err != nil {
+	if newRow.ActiveFrom != nil {
+		columns = append(columns, "active_from")
+		values = append(values, *newRow.ActiveFrom)
+	}
+	if newRow.CreatedAt != nil {
+		columns = append(columns, "created_at")
+		values = append(values, *newRow.CreatedAt)
+	}
+	if newRow.PasswordHash != nil {
+		columns = append(columns, "password_hash")
+		values = append(values, *newRow.PasswordHash)
+	}
+	if newRow.ForcePreview {
+		columns = append(columns, "force_preview")
+		values = append(values, true)
+	}
+	if newRow.ForwardQuery != ForwardQueryOff {
+		columns = append(columns, "forward_query")
+		values = append(values, newRow.ForwardQuery)
+	}
+	if newRow.ForwardPath {
+		columns = append(columns, "forward_path")
+		values = append(values, true)
+	}
+	if len(newRow.Rules) > 0 {
+		columns = append(columns, "rules")
+		values = append(values, newRow.Rules)
+	}
+	if len(newRow.Variants) > 0 {
+		columns = append(columns, "variants")
+		values = append(values, newRow.Variants)
+	}
+	if newRow.Status != 0 {
+		columns = append(columns, "redirect_status")
+		values = append(values, newRow.Status)
+	}
+	if newRow.FallbackUrl != nil {
+		columns = append(columns, "fallback_url")
+		values = append(values, *newRow.FallbackUrl)
+	}
+	if newRow.Social != nil && !newRow.Social.empty() {
+		columns = append(columns, "social")
+		values = append(values, *newRow.Social)
+	}
+	if newRow.MaxClicks != nil {
+		columns = append(columns, "max_clicks", "remaining_clicks")
+		values = append(values, *newRow.MaxClicks, *newRow.MaxClicks)
+	}
+
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+
+	row := q.QueryRowContext(
+		ctx,
+		fmt.Sprintf(
+			"INSERT INTO urls (%s) VALUES (%s) ON CONFLICT (short_url) DO NOTHING RETURNING id",
+			strings.Join(columns, ", "),
+			strings.Join(placeholders, ", "),
+		),
+		values...,
+	)
+
+	var id int
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
 		s.logger.Error("Failed to execute insert query", "error", err.Error())
+		return false, ErrExecQuery
+	}
+
+	return true, nil
+}
+
+// findOwnedUrlId resolves code to its row id, making sure it belongs to
+// userId.
+func (s *service) findOwnedUrlId(ctx context.Context, code string, userId int) (int, error) {
+	var id, ownerId int
+
+	row := s.db.QueryRowContext(ctx, "SELECT id, user_id FROM urls WHERE short_url = $1", code)
+	if err := row.Scan(&id, &ownerId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return 0, ErrExecQuery
+	}
+
+	if ownerId != userId {
+		return 0, ErrForbidden
+	}
+
+	return id, nil
+}
+
+func (s *service) GetUrlStats(ctx context.Context, code string, userId int, query StatsQuery) (*UrlStats, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &UrlStats{
+		Code:     code,
+		From:     query.From.In(query.Location),
+		To:       query.To.In(query.Location),
+		Interval: query.Interval,
+		TimeZone: query.Location.String(),
+	}
+
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), COUNT(DISTINCT NULLIF(ip_hash, '')) FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3",
+		urlId,
+		query.From,
+		query.To,
+	)
+	if err := row.Scan(&stats.TotalClicks, &stats.UniqueClicks); err != nil {
+		s.logger.Error("Failed to count clicks", "error", err.Error())
 		return nil, ErrExecQuery
 	}
 
-	newURL := s.cfg.APP_BASE_URL + "/" + shortCode
+	if stats.Series, err = s.clickSeries(ctx, urlId, query); err != nil {
+		return nil, err
+	}
 
-	return &newURL, nil
+	stats.TopReferrers, err = s.topClickCounts(
+		ctx,
+		`SELECT COALESCE(lower(substring(referrer from '^[A-Za-z][A-Za-z0-9+.-]*://([^/?#:]+)')), 'direct'), COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $4`,
+		urlId, query.From, query.To, statsTopLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Sources, err = s.topClickCounts(
+		ctx,
+		`SELECT COALESCE(NULLIF(source, ''), 'direct'), COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3
+		GROUP BY 1 ORDER BY 2 DESC, 1`,
+		urlId, query.From, query.To,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Variants, err = s.topClickCounts(
+		ctx,
+		`SELECT variant, COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3 AND variant <> ''
+		GROUP BY 1 ORDER BY 2 DESC, 1`,
+		urlId, query.From, query.To,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TopCountries, err = s.topClickCounts(
+		ctx,
+		`SELECT COALESCE(NULLIF(country, ''), 'unknown'), COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $4`,
+		urlId, query.From, query.To, statsTopLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Browsers are classified in Go, so every distinct user agent is
+	// fetched and folded into its browser family here.
+	agents, err := s.topClickCounts(
+		ctx,
+		`SELECT user_agent, COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3
+		GROUP BY 1`,
+		urlId, query.From, query.To,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	browsers := make(map[string]int64)
+	for _, agent := range agents {
+		browsers[useragent.Parse(agent.Name).Browser] += agent.Clicks
+	}
+	stats.TopBrowsers = topCounts(browsers, statsTopLimit)
+
+	return stats, nil
+}
+
+func (s *service) clickSeries(ctx context.Context, urlId int, query StatsQuery) ([]StatsBucket, error) {
+	const wallClock = "2006-01-02 15:04"
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT date_trunc($2, clicked_at AT TIME ZONE $3), COUNT(*)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $4 AND clicked_at < $5
+		GROUP BY 1`,
+		urlId,
+		query.Interval,
+		query.Location.String(),
+		query.From,
+		query.To,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query click series", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var bucket time.Time
+		var clicks int64
+		if err := rows.Scan(&bucket, &clicks); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		counts[bucket.Format(wallClock)] += clicks
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	buckets := statsBuckets(query)
+	series := make([]StatsBucket, 0, len(buckets))
+	for _, start := range buckets {
+		series = append(series, StatsBucket{
+			Start:  start,
+			Clicks: counts[start.Format(wallClock)],
+		})
+	}
+
+	return series, nil
+}
+
+func (s *service) topClickCounts(ctx context.Context, query string, args ...any) ([]StatsCount, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		s.logger.Error("Failed to query click counts", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	counts := []StatsCount{}
+	for rows.Next() {
+		var count StatsCount
+		if err := rows.Scan(&count.Name, &count.Clicks); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return counts, nil
+}
+
+func topCounts(counts map[string]int64, limit int) []StatsCount {
+	top := make([]StatsCount, 0, len(counts))
+	for name, clicks := range counts {
+		top = append(top, StatsCount{Name: name, Clicks: clicks})
+	}
+
+	slices.SortFunc(top, func(a, b StatsCount) int {
+		if c := cmp.Compare(b.Clicks, a.Clicks); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if len(top) > limit {
+		top = top[:limit]
+	}
+
+	return top
 }
 
-func (s *service) generateRandomCode() string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// sqlUrlHost extracts the lower-cased host of a URL column.
+const sqlUrlHost = `lower(substring(%s from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^/?#:]+)'))`
+
+// sqlCurrentLongUrl is where the link u leads right now, its scheduled
+// destination if one has started.
+const sqlCurrentLongUrl = `COALESCE(
+	(SELECT s.long_url FROM url_schedule s
+	WHERE s.url_id = u.id AND s.starts_at <= NOW()
+	ORDER BY s.starts_at DESC LIMIT 1),
+	u.long_url
+)`
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 
-	shortCode := make([]byte, 7)
-	for i := range shortCode {
-		shortCode[i] = charset[s.rand.Intn(len(charset))]
+func (s *service) ListUrls(ctx context.Context, userId int, query ListQuery) (*LinkPage, error) {
+	args := []any{userId}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
 	}
 
-	return string(shortCode)
+	filters := []string{"u.user_id = $1"}
+
+	switch query.Status {
+	case ListStatusActive:
+		filters = append(filters, `u.deleted_at IS NULL AND u.disabled_at IS NULL AND u.flagged_at IS NULL AND u.expires_at > NOW()
+			AND (u.active_from IS NULL OR u.active_from <= NOW())
+			AND (u.remaining_clicks IS NULL OR u.remaining_clicks > 0)`)
+	case ListStatusScheduled:
+		filters = append(filters, "u.deleted_at IS NULL AND u.active_from > NOW()")
+	case ListStatusExpired:
+		filters = append(filters, "u.deleted_at IS NULL AND u.expires_at <= NOW()")
+	case ListStatusDisabled:
+		filters = append(filters, "u.deleted_at IS NULL AND u.disabled_at IS NOT NULL")
+	case ListStatusExhausted:
+		filters = append(filters, "u.deleted_at IS NULL AND u.remaining_clicks = 0")
+	case ListStatusTrash:
+		filters = append(filters, "u.deleted_at IS NOT NULL")
+	default:
+		filters = append(filters, "u.deleted_at IS NULL")
+	}
+
+	if query.Domain != "" {
+		host := fmt.Sprintf(sqlUrlHost, "u.long_url")
+		domain := arg(query.Domain)
+		filters = append(filters, fmt.Sprintf("(%s = %s OR %s LIKE '%%.' || %s)", host, domain, host, domain))
+	}
+
+	if query.Health != "" {
+		filters = append(filters, "u.health_status = "+arg(query.Health))
+	}
+
+	if query.Search != "" {
+		pattern := arg("%" + likeEscaper.Replace(query.Search) + "%")
+		filters = append(filters, fmt.Sprintf(
+			"(u.short_url ILIKE %s OR u.long_url ILIKE %s OR u.metadata->>'title' ILIKE %s)",
+			pattern, pattern, pattern,
+		))
+	}
+
+	sortColumn, sortType := "created_at", "timestamptz"
+	switch query.Sort {
+	case ListSortExpires:
+		sortColumn = "expires_at"
+	case ListSortClicks:
+		sortColumn, sortType = "clicks", "bigint"
+	}
+
+	direction, comparison := "DESC", "<"
+	if query.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	cursorFilter := ""
+	if query.Cursor != nil {
+		cursorFilter = fmt.Sprintf(
+			"WHERE (%s, id) %s (%s::%s, %s)",
+			sortColumn, comparison, arg(query.Cursor.Value), sortType, arg(query.Cursor.Id),
+		)
+	}
+
+	statement := fmt.Sprintf(
+		`SELECT id, short_url, long_url, created_at, expires_at, active_from, disabled_at, deleted_at, protected, force_preview, forward_query, forward_path, rules, variants, redirect_status, flagged_at, flag_reason, fallback_url, health_status, health_checked_at, metadata, metadata_fetched_at, metadata_error, social, max_clicks, remaining_clicks, clicks FROM (
+			SELECT u.id, u.short_url, u.long_url, u.created_at, u.expires_at, u.active_from, u.disabled_at, u.deleted_at,
+				u.password_hash IS NOT NULL AS protected, u.force_preview, u.forward_query, u.forward_path, u.rules, u.variants, u.redirect_status, u.flagged_at, u.flag_reason,
+				u.fallback_url, u.health_status, u.health_checked_at, u.metadata, u.metadata_fetched_at, u.metadata_error, u.social,
+				u.max_clicks, u.remaining_clicks,
+				(SELECT COUNT(*) FROM clicks c WHERE c.url_id = u.id) AS clicks
+			FROM urls u
+			WHERE %s
+		) links
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %s`,
+		strings.Join(filters, " AND "),
+		cursorFilter,
+		sortColumn, direction, direction,
+		arg(query.Limit+1),
+	)
+
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		s.logger.Error("Failed to query urls", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	var ids []int
+	page := &LinkPage{Links: []Link{}}
+	for rows.Next() {
+		var id int
+		var link Link
+		if err := rows.Scan(&id, &link.Code, &link.LongUrl, &link.CreatedAt, &link.ExpiresAt, &link.ActiveFrom, &link.DisabledAt, &link.DeletedAt, &link.Protected, &link.Preview, &link.ForwardQuery, &link.ForwardPath, &link.Rules, &link.Variants, &link.RedirectStatus, &link.FlaggedAt, &link.FlagReason, &link.FallbackUrl, &link.Health, &link.HealthCheckedAt, &link.Metadata, &link.MetadataFetchedAt, &link.MetadataError, &link.Social, &link.MaxClicks, &link.RemainingClicks, &link.Clicks); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		link.ShortUrl = s.cfg.APP_BASE_URL + "/" + link.Code
+		ids = append(ids, id)
+		page.Links = append(page.Links, link)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	if len(page.Links) > query.Limit {
+		page.Links = page.Links[:query.Limit]
+		last := page.Links[query.Limit-1]
+
+		cursor := ListCursor{Sort: query.Sort, Id: ids[query.Limit-1]}
+		switch query.Sort {
+		case ListSortExpires:
+			cursor.Value = last.ExpiresAt.Format(time.RFC3339Nano)
+		case ListSortClicks:
+			cursor.Value = strconv.FormatInt(last.Clicks, 10)
+		default:
+			cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		page.NextCursor = cursor.Encode()
+	}
+
+	return page, nil
+}
+
+func (s *service) UpdateUrl(ctx context.Context, code string, userId int, update UpdateURL) (*Link, error) {
+	var rules RedirectRules
+	if update.Rules != nil {
+		rules = *update.Rules
+	}
+	var variants Variants
+	if update.Variants != nil {
+		variants = *update.Variants
+	}
+	if err := s.checkDestinations(update.Url, update.FallbackUrl, rules, variants); err != nil {
+		return nil, err
+	}
+
+	link, err := s.changeUrl(ctx, code, userId, func(_ *sql.Tx, _ int, current LinkSnapshot) (LinkSnapshot, error) {
+		next := current
+		if update.Url != nil {
+			next.LongUrl = *update.Url
+		}
+		if update.Expires != nil {
+			next.ExpiresAt = *update.Expires
+		}
+		if update.ActiveFrom != nil {
+			next.ActiveFrom = update.ActiveFrom
+		}
+		if update.ClearActiveFrom {
+			next.ActiveFrom = nil
+		}
+		if update.Preview != nil {
+			next.Preview = *update.Preview
+		}
+		if update.ForwardQuery != nil {
+			next.ForwardQuery = *update.ForwardQuery
+		}
+		if update.ForwardPath != nil {
+			next.ForwardPath = *update.ForwardPath
+		}
+		if update.Rules != nil {
+			next.Rules = *update.Rules
+		}
+		if update.Variants != nil {
+			next.Variants = *update.Variants
+		}
+		if update.RedirectStatus != nil {
+			next.RedirectStatus = nil
+			if *update.RedirectStatus != 0 {
+				next.RedirectStatus = update.RedirectStatus
+			}
+		}
+		if update.FallbackUrl != nil {
+			next.FallbackUrl = nil
+			if *update.FallbackUrl != "" {
+				next.FallbackUrl = update.FallbackUrl
+			}
+		}
+		if update.Social != nil {
+			next.Social = nil
+			if !update.Social.empty() {
+				next.Social = update.Social
+			}
+		}
+		if next.ActiveFrom != nil && !next.ActiveFrom.Before(next.ExpiresAt) {
+			return LinkSnapshot{}, ErrActiveAfterExpiry
+		}
+		return next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// changeUrl clears the fetch time whenever the destination changes.
+	if link.MetadataFetchedAt == nil {
+		s.fetchMetadataLater(code)
+	}
+
+	return link, nil
+}
+
+// RollbackUrl restores the settings saved in history entry historyId. They
+// go through the same destination checks as an update, what was allowed
+// back then may not be now. Flagged links can't be rolled back.
+func (s *service) RollbackUrl(ctx context.Context, code string, userId int, historyId int) (*Link, error) {
+	link, err := s.changeUrl(ctx, code, userId, func(tx *sql.Tx, urlId int, _ LinkSnapshot) (LinkSnapshot, error) {
+		var flagged bool
+		row := tx.QueryRowContext(ctx, "SELECT flagged_at IS NOT NULL FROM urls WHERE id = $1", urlId)
+		if err := row.Scan(&flagged); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return LinkSnapshot{}, ErrExecQuery
+		}
+		if flagged {
+			return LinkSnapshot{}, ErrFlagged
+		}
+
+		var previous []byte
+
+		row = tx.QueryRowContext(ctx, "SELECT previous FROM url_history WHERE id = $1 AND url_id = $2", historyId, urlId)
+		if err := row.Scan(&previous); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return LinkSnapshot{}, ErrHistoryNotFound
+			}
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return LinkSnapshot{}, ErrExecQuery
+		}
+
+		var snapshot LinkSnapshot
+		if err := json.Unmarshal(previous, &snapshot); err != nil {
+			s.logger.Error("Failed to decode history snapshot", "error", err.Error())
+			return LinkSnapshot{}, ErrExecQuery
+		}
+
+		if err := s.checkDestinations(&snapshot.LongUrl, snapshot.FallbackUrl, snapshot.Rules, snapshot.Variants); err != nil {
+			return LinkSnapshot{}, err
+		}
+
+		return snapshot, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// changeUrl clears the fetch time whenever the destination changes.
+	if link.MetadataFetchedAt == nil {
+		s.fetchMetadataLater(code)
+	}
+
+	return link, nil
+}
+
+// changeUrl locks the link, lets change compute its new settings and stores
+// the old ones in url_history before writing the new ones, all in one
+// transaction.
+func (s *service) changeUrl(
+	ctx context.Context,
+	code string,
+	userId int,
+	change func(tx *sql.Tx, urlId int, current LinkSnapshot) (LinkSnapshot, error),
+) (*Link, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin update transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer tx.Rollback()
+
+	var urlId, ownerId int
+	var current LinkSnapshot
+
+	row := tx.QueryRowContext(
+		ctx,
+		`SELECT id, user_id, long_url, expires_at, active_from, force_preview, forward_query, forward_path, rules, variants, redirect_status,
+			fallback_url, social
+		FROM urls WHERE short_url = $1 AND deleted_at IS NULL FOR UPDATE`,
+		code,
+	)
+	err = row.Scan(
+		&urlId, &ownerId, &current.LongUrl, &current.ExpiresAt, &current.ActiveFrom, &current.Preview,
+		&current.ForwardQuery, &current.ForwardPath, &current.Rules, &current.Variants,
+		&current.RedirectStatus, &current.FallbackUrl, &current.Social,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	if ownerId != userId {
+		return nil, ErrForbidden
+	}
+
+	next, err := change(tx, urlId, current)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO url_history (url_id, changed_by, previous) VALUES ($1, $2, $3)",
+		urlId,
+		userId,
+		previous,
+	)
+	if err != nil {
+		s.logger.Error("Failed to insert url history", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE urls SET long_url = $2, expires_at = $3, active_from = $4, force_preview = $5,
+			forward_query = $6, forward_path = $7, rules = $8,
+			variants = $9, redirect_status = $10, fallback_url = $11,
+			social = $12,
+			-- An admin cleared the destinations they looked at, new ones get
+			-- rechecked.
+			flag_cleared_at = CASE
+				WHEN long_url IS DISTINCT FROM $2 OR fallback_url IS DISTINCT FROM $11
+					OR rules IS DISTINCT FROM $8 OR variants IS DISTINCT FROM $9 THEN NULL
+				ELSE flag_cleared_at
+			END,
+			metadata_fetched_at = CASE WHEN long_url IS DISTINCT FROM $2 THEN NULL ELSE metadata_fetched_at END
+		WHERE id = $1`,
+		urlId,
+		next.LongUrl,
+		next.ExpiresAt,
+		next.ActiveFrom,
+		next.Preview,
+		next.ForwardQuery,
+		next.ForwardPath,
+		next.Rules,
+		next.Variants,
+		next.RedirectStatus,
+		next.FallbackUrl,
+		next.Social,
+	)
+	if err != nil {
+		s.logger.Error("Failed to update url", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit update transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	s.logger.Info("Short URL updated", "code", code, "user_id", userId)
+
+	return s.getLink(ctx, urlId)
+}
+
+func (s *service) GetUrlHistory(ctx context.Context, code string, userId int) ([]HistoryEntry, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, changed_by, changed_at, previous FROM url_history WHERE url_id = $1 ORDER BY id DESC",
+		urlId,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query url history", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		var changedBy sql.NullInt64
+		var previous []byte
+
+		if err := rows.Scan(&entry.Id, &changedBy, &entry.ChangedAt, &previous); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		if changedBy.Valid {
+			id := int(changedBy.Int64)
+			entry.ChangedBy = &id
+		}
+		if err := json.Unmarshal(previous, &entry.Previous); err != nil {
+			s.logger.Error("Failed to decode history snapshot", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return history, nil
+}
+
+func (s *service) GetUrlSchedule(ctx context.Context, code string, userId int) ([]ScheduleEntry, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getSchedule(ctx, s.db, urlId)
+}
+
+// UpdateUrlSchedule replaces the destination schedule of a link with
+// entries.
+func (s *service) UpdateUrlSchedule(ctx context.Context, code string, userId int, entries []ScheduleEntry) ([]ScheduleEntry, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(types.ValidationErrors)
+	for i, entry := range entries {
+		if reason := s.checkDestination(entry.Url); reason != "" {
+			errs[fmt.Sprintf("entries[%d].url", i)] = reason
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin schedule transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM url_schedule WHERE url_id = $1", urlId); err != nil {
+		s.logger.Error("Failed to clear url schedule", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	for _, entry := range entries {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO url_schedule (url_id, starts_at, long_url) VALUES ($1, $2, $3)",
+			urlId,
+			entry.StartsAt,
+			entry.Url,
+		)
+		if err != nil {
+			s.logger.Error("Failed to insert url schedule entry", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+	}
+
+	// Scheduled destinations weren't part of what an admin cleared, and the
+	// current one may have changed.
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE urls SET metadata_fetched_at = NULL,
+			flag_cleared_at = CASE WHEN $2 THEN NULL ELSE flag_cleared_at END
+		WHERE id = $1`,
+		urlId,
+		len(entries) > 0,
+	)
+	if err != nil {
+		s.logger.Error("Failed to reset url checks", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	schedule, err := s.getSchedule(ctx, tx, urlId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit schedule transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	s.logger.Info("Short URL schedule updated", "code", code, "user_id", userId, "entries", len(schedule))
+
+	s.fetchMetadataLater(code)
+
+	return schedule, nil
+}
+
+func (s *service) getSchedule(ctx context.Context, q dbtx, urlId int) ([]ScheduleEntry, error) {
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT starts_at, long_url FROM url_schedule WHERE url_id = $1 ORDER BY starts_at",
+		urlId,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query url schedule", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	schedule := []ScheduleEntry{}
+	for rows.Next() {
+		var entry ScheduleEntry
+		if err := rows.Scan(&entry.StartsAt, &entry.Url); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		schedule = append(schedule, entry)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return schedule, nil
+}
+
+func (s *service) getLink(ctx context.Context, urlId int) (*Link, error) {
+	var link Link
+
+	row := s.db.QueryRowContext(
+		ctx,
+		`SELECT u.short_url, u.long_url, u.created_at, u.expires_at, u.active_from, u.disabled_at, u.deleted_at,
+			u.password_hash IS NOT NULL, u.force_preview, u.forward_query, u.forward_path, u.rules, u.variants, u.redirect_status,
+			u.flagged_at, u.flag_reason, u.fallback_url, u.health_status, u.health_checked_at,
+			u.metadata, u.metadata_fetched_at, u.metadata_error, u.social,
+			u.max_clicks, u.remaining_clicks, (SELECT COUNT(*) FROM clicks c WHERE c.url_id = u.id)
+		FROM urls u WHERE u.id = $1`,
+		urlId,
+	)
+	if err := row.Scan(&link.Code, &link.LongUrl, &link.CreatedAt, &link.ExpiresAt, &link.ActiveFrom, &link.DisabledAt, &link.DeletedAt, &link.Protected, &link.Preview, &link.ForwardQuery, &link.ForwardPath, &link.Rules, &link.Variants, &link.RedirectStatus, &link.FlaggedAt, &link.FlagReason, &link.FallbackUrl, &link.Health, &link.HealthCheckedAt, &link.Metadata, &link.MetadataFetchedAt, &link.MetadataError, &link.Social, &link.MaxClicks, &link.RemainingClicks, &link.Clicks); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		s.logger.Error("An error occurred when scanning row", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	link.ShortUrl = s.cfg.APP_BASE_URL + "/" + link.Code
+
+	return &link, nil
+}
+
+func (s *service) SetUrlDisabled(ctx context.Context, code string, userId int, disabled bool) (*Link, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !disabled {
+		// Only an admin clearing the flag brings a flagged link back.
+		var flagged bool
+		row := s.db.QueryRowContext(ctx, "SELECT flagged_at IS NOT NULL FROM urls WHERE id = $1", urlId)
+		if err := row.Scan(&flagged); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		if flagged {
+			return nil, ErrFlagged
+		}
+	}
+
+	statement := "UPDATE urls SET disabled_at = NULL WHERE id = $1 AND deleted_at IS NULL"
+	if disabled {
+		statement = "UPDATE urls SET disabled_at = COALESCE(disabled_at, NOW()) WHERE id = $1 AND deleted_at IS NULL"
+	}
+
+	if err := s.execOnUrl(ctx, statement, urlId); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Short URL availability changed", "code", code, "disabled", disabled)
+
+	return s.getLink(ctx, urlId)
+}
+
+// DeleteUrl moves the link to the trash. It stops resolving right away but
+// keeps its clicks until PurgeTrash removes it for good.
+func (s *service) DeleteUrl(ctx context.Context, code string, userId int) error {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.execOnUrl(ctx, "UPDATE urls SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL", urlId); err != nil {
+		return err
+	}
+
+	s.logger.Info("Short URL moved to trash", "code", code)
+
+	return nil
+}
+
+func (s *service) RestoreUrl(ctx context.Context, code string, userId int) (*Link, error) {
+	urlId, err := s.findOwnedUrlId(ctx, code, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.execOnUrl(ctx, "UPDATE urls SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", urlId); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Short URL restored from trash", "code", code)
+
+	return s.getLink(ctx, urlId)
+}
+
+// execOnUrl runs a single-row update and reports ErrNotFound when the row
+// did not match, e.g. restoring a link that is not in the trash.
+func (s *service) execOnUrl(ctx context.Context, statement string, urlId int) error {
+	result, err := s.db.ExecContext(ctx, statement, urlId)
+	if err != nil {
+		s.logger.Error("Failed to update url", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeTrash hard-deletes links that have been in the trash for longer than
+// TRASH_RETENTION, their clicks and history go with them.
+func (s *service) PurgeTrash(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-s.cfg.TRASH_RETENTION)
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM urls WHERE deleted_at < $1", cutoff)
+	if err != nil {
+		s.logger.Error("Failed to purge trash", "error", err.Error())
+		return 0, ErrExecQuery
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if purged > 0 {
+		s.logger.Info("Trash purged", "count", purged)
+	}
+
+	return purged, nil
+}
+
+// CreateBulkShortUrls inserts every row in one transaction. In atomic mode a
+// single bad row rolls back the whole batch, in best-effort mode each row
+// runs under its own savepoint and only the failing rows are skipped.
+func (s *service) CreateBulkShortUrls(ctx context.Context, rows []BulkRow, userId int, mode string) (*BulkReport, error) {
+	if mode == "" {
+		mode = s.cfg.BULK_MODE
+	}
+	if mode != BulkModeBestEffort {
+		mode = BulkModeAtomic
+	}
+
+	if s.cfg.BULK_MAX_ROWS > 0 && len(rows) > s.cfg.BULK_MAX_ROWS {
+		return nil, ErrTooManyRows
+	}
+
+	report := &BulkReport{
+		Mode:    mode,
+		Results: make([]BulkResult, len(rows)),
+	}
+
+	for i, row := range rows {
+		report.Results[i] = BulkResult{Index: i, Line: row.Line, Url: row.Url}
+
+		errs := row.Errors
+		if errs == nil {
+			errs = s.validateBulkRow(row.URL)
+		}
+		if errs != nil {
+			report.Results[i].Errors = errs
+			report.Failed++
+		}
+	}
+
+	if mode == BulkModeAtomic && report.Failed > 0 {
+		return report, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin bulk transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer tx.Rollback()
+
+	var created []string
+	for i, row := range rows {
+		result := &report.Results[i]
+		if result.Errors != nil {
+			continue
+		}
+
+		if mode == BulkModeBestEffort {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_row"); err != nil {
+				s.logger.Error("Failed to create savepoint", "error", err.Error())
+				return nil, ErrExecQuery
+			}
+		}
+
+		shortCode, err := s.createUrl(ctx, tx, row.URL, userId)
+		if err != nil {
+			result.Errors = bulkRowError(err)
+			report.Failed++
+
+			if mode == BulkModeAtomic {
+				return discardBulk(report), nil
+			}
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_row"); err != nil {
+				s.logger.Error("Failed to roll back savepoint", "error", err.Error())
+				return nil, ErrExecQuery
+			}
+			continue
+		}
+
+		if mode == BulkModeBestEffort {
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT bulk_row"); err != nil {
+				s.logger.Error("Failed to release savepoint", "error", err.Error())
+				return nil, ErrExecQuery
+			}
+		}
+
+		result.ShortUrl = s.cfg.APP_BASE_URL + "/" + shortCode
+		report.Created++
+		created = append(created, shortCode)
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit bulk transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	for _, code := range created {
+		s.fetchMetadataLater(code)
+	}
+
+	s.logger.Info("Bulk short URLs created", "user_id", userId, "created", report.Created, "failed", report.Failed)
+
+	return report, nil
+}
+
+func (s *service) validateBulkRow(u URL) types.ValidationErrors {
+	err := u.Validate()
+	if err == nil {
+		err = s.checkDestinations(&u.Url, &u.FallbackUrl, u.Rules, u.Variants)
+	}
+	if err != nil {
+		var validationErrs types.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			return validationErrs
+		}
+		return types.ValidationErrors{"row": err.Error()}
+	}
+
+	return nil
+}
+
+func bulkRowError(err error) types.ValidationErrors {
+	var validationErrs types.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return validationErrs
+	}
+
+	switch err {
+	case ErrAliasTaken:
+		return types.ValidationErrors{"alias": "already taken"}
+	default:
+		return types.ValidationErrors{"row": "could not be created"}
+	}
+}
+
+// discardBulk clears the short URLs of rows that were rolled back together
+// with the failing one.
+func discardBulk(report *BulkReport) *BulkReport {
+	for i := range report.Results {
+		report.Results[i].ShortUrl = ""
+	}
+	report.Created = 0
+
+	return report
+}
+
+// ExportUrls streams every link owned by userId, trashed ones included, to
+// fn one row at a time. An error returned by fn stops the export.
+func (s *service) ExportUrls(ctx context.Context, userId int, fn func(Link) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT u.short_url, u.long_url, u.created_at, u.expires_at, u.active_from, u.disabled_at, u.deleted_at,
+			u.password_hash IS NOT NULL, u.force_preview, u.forward_query, u.forward_path, u.rules, u.variants, u.redirect_status,
+			u.flagged_at, u.flag_reason, u.fallback_url, u.health_status, u.health_checked_at,
+			u.metadata, u.metadata_fetched_at, u.metadata_error, u.social,
+			u.max_clicks, u.remaining_clicks, (SELECT COUNT(*) FROM clicks c WHERE c.url_id = u.id)
+		FROM urls u WHERE u.user_id = $1
+		ORDER BY u.id`,
+		userId,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query urls for export", "error", err.Error())
+		return ErrExecQuery
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link Link
+		if err := rows.Scan(&link.Code, &link.LongUrl, &link.CreatedAt, &link.ExpiresAt, &link.ActiveFrom, &link.DisabledAt, &link.DeletedAt, &link.Protected, &link.Preview, &link.ForwardQuery, &link.ForwardPath, &link.Rules, &link.Variants, &link.RedirectStatus, &link.FlaggedAt, &link.FlagReason, &link.FallbackUrl, &link.Health, &link.HealthCheckedAt, &link.Metadata, &link.MetadataFetchedAt, &link.MetadataError, &link.Social, &link.MaxClicks, &link.RemainingClicks, &link.Clicks); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return ErrExecQuery
+		}
+		link.ShortUrl = s.cfg.APP_BASE_URL + "/" + link.Code
+
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	return nil
+}
+
+// ExportClicks streams the raw clicks of every link owned by userId to fn.
+func (s *service) ExportClicks(ctx context.Context, userId int, fn func(ExportedClick) error) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT c.short_code, c.clicked_at, c.referrer, c.user_agent, c.country, c.accept_language, c.source, c.variant
+		FROM clicks c JOIN urls u ON u.id = c.url_id
+		WHERE u.user_id = $1
+		ORDER BY c.id`,
+		userId,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query clicks for export", "error", err.Error())
+		return ErrExecQuery
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var click ExportedClick
+		if err := rows.Scan(&click.Code, &click.ClickedAt, &click.Referrer, &click.UserAgent, &click.Country, &click.AcceptLanguage, &click.Source, &click.Variant); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return ErrExecQuery
+		}
+
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	return nil
+}
+
+// ImportUrls creates links owned by userId while keeping their original
+// codes. Codes that are already taken are reported as conflicts and left
+// untouched, every other row is still imported.
+func (s *service) ImportUrls(ctx context.Context, userId int, links []ImportedLink) (*ImportReport, error) {
+	report := &ImportReport{
+		Results: make([]ImportResult, len(links)),
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		s.logger.Error("Failed to begin import transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer tx.Rollback()
+
+	for i, link := range links {
+		result := &report.Results[i]
+		*result = ImportResult{Code: link.Code, Url: link.Url}
+
+		reason := validateImportedCode(link.Code)
+		if reason == "" {
+			if policyReason := s.checkDestination(link.Url); policyReason != "" {
+				reason = "destination " + policyReason
+			}
+		}
+		if reason != "" {
+			result.Status, result.Reason = ImportStatusInvalid, reason
+			report.Invalid++
+			continue
+		}
+
+		inserted, err := s.insertURL(ctx, tx, urlRow{
+			UserId:    userId,
+			ShortCode: link.Code,
+			LongUrl:   link.Url,
+			ExpiresAt: link.ExpiresAt,
+			CreatedAt: link.CreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !inserted {
+			result.Status, result.Reason = ImportStatusConflict, "short code already in use"
+			report.Conflicts++
+			continue
+		}
+
+		result.Status = ImportStatusImported
+		report.Imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("Failed to commit import transaction", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	for _, result := range report.Results {
+		if result.Status == ImportStatusImported {
+			s.fetchMetadataLater(result.Code)
+		}
+	}
+
+	s.logger.Info(
+		"Short URLs imported",
+		"user_id", userId,
+		"imported", report.Imported,
+		"conflicts", report.Conflicts,
+		"invalid", report.Invalid,
+	)
+
+	return report, nil
+}
+
+func (s *service) ListPreviewDomains(ctx context.Context) ([]PreviewDomain, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT domain, created_by, created_at FROM preview_domains ORDER BY domain")
+	if err != nil {
+		s.logger.Error("Failed to query preview domains", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	domains := []PreviewDomain{}
+	for rows.Next() {
+		var domain PreviewDomain
+		if err := rows.Scan(&domain.Domain, &domain.CreatedBy, &domain.CreatedAt); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		domains = append(domains, domain)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return domains, nil
+}
+
+// AddPreviewDomain forces the interstitial on every link to domain and its
+// subdomains.
+func (s *service) AddPreviewDomain(ctx context.Context, domain string, userId int) (*PreviewDomain, error) {
+	added := PreviewDomain{Domain: normalizeDomain(domain)}
+
+	row := s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO preview_domains (domain, created_by) VALUES ($1, $2)
+		ON CONFLICT (domain) DO NOTHING
+		RETURNING created_by, created_at`,
+		added.Domain,
+		userId,
+	)
+	if err := row.Scan(&added.CreatedBy, &added.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDomainExists
+		}
+		s.logger.Error("Failed to insert preview domain", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	s.logger.Info("Preview domain added", "domain", added.Domain, "user_id", userId)
+
+	return &added, nil
+}
+
+func (s *service) RemovePreviewDomain(ctx context.Context, domain string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM preview_domains WHERE domain = $1", normalizeDomain(domain))
+	if err != nil {
+		s.logger.Error("Failed to delete preview domain", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.Error("Failed to read affected rows", "error", err.Error())
+		return ErrExecQuery
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	s.logger.Info("Preview domain removed", "domain", domain)
+
+	return nil
+}
+
+// ReloadDestinationPolicy reads the domains file again, the current lists
+// stay in place when it can't be loaded.
+func (s *service) ReloadDestinationPolicy() (PolicySummary, error) {
+	summary, err := s.policy.Reload()
+	if err != nil {
+		s.logger.Error("Failed to reload destination policy", "error", err.Error())
+		return PolicySummary{}, err
+	}
+
+	return summary, nil
+}
+
+// reputationBatchSize is how many links RecheckReputation reads at once.
+const reputationBatchSize = 500
+
+type reputationHit struct {
+	id     int
+	code   string
+	reason string
+}
+
+// RecheckReputation runs every live link through the reputation lists and
+// flags, and disables, those that now match one. Links an admin cleared
+// are left alone, new destinations are still checked when they are set.
+// It returns how many links were flagged.
+func (s *service) RecheckReputation(ctx context.Context) (int, error) {
+	if !s.reputation.Enabled() {
+		return 0, nil
+	}
+
+	flagged, lastId := 0, 0
+	for {
+		hits, last, err := s.reputationBatch(ctx, lastId)
+		if err != nil {
+			return flagged, err
+		}
+
+		for _, hit := range hits {
+			_, err := s.db.ExecContext(
+				ctx,
+				`UPDATE urls SET flagged_at = NOW(), flag_reason = $2, disabled_at = COALESCE(disabled_at, NOW())
+				WHERE id = $1 AND flagged_at IS NULL`,
+				hit.id,
+				hit.reason,
+			)
+			if err != nil {
+				s.logger.Error("Failed to flag url", "error", err.Error())
+				return flagged, ErrExecQuery
+			}
+
+			s.logger.Warn("Short URL flagged as unsafe", "code", hit.code, "reason", hit.reason)
+			flagged++
+		}
+
+		if last == lastId {
+			break
+		}
+		lastId = last
+	}
+
+	s.logger.Info("Reputation recheck finished", "flagged", flagged)
+
+	return flagged, nil
+}
+
+// reputationBatch checks the links after lastId and returns the matches
+// along with the last id it read.
+func (s *service) reputationBatch(ctx context.Context, lastId int) ([]reputationHit, int, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT u.id, u.short_url, u.long_url, u.fallback_url, u.rules, u.variants,
+			COALESCE((SELECT jsonb_agg(s.long_url) FROM url_schedule s WHERE s.url_id = u.id), '[]')
+		FROM urls u
+		WHERE u.id > $1 AND u.deleted_at IS NULL AND u.flagged_at IS NULL AND u.flag_cleared_at IS NULL
+		ORDER BY u.id LIMIT $2`,
+		lastId,
+		reputationBatchSize,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query urls for reputation check", "error", err.Error())
+		return nil, lastId, ErrExecQuery
+	}
+	defer rows.Close()
+
+	var hits []reputationHit
+	for rows.Next() {
+		var code, longUrl string
+		var fallbackUrl *string
+		var rules RedirectRules
+		var variants Variants
+		var scheduled []byte
+
+		if err := rows.Scan(&lastId, &code, &longUrl, &fallbackUrl, &rules, &variants, &scheduled); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, lastId, ErrExecQuery
+		}
+
+		destinations := []string{longUrl}
+		if fallbackUrl != nil {
+			destinations = append(destinations, *fallbackUrl)
+		}
+		for _, rule := range rules {
+			destinations = append(destinations, rule.Url)
+		}
+		for _, variant := range variants {
+			destinations = append(destinations, variant.Url)
+		}
+		var scheduledUrls []string
+		if err := json.Unmarshal(scheduled, &scheduledUrls); err == nil {
+			destinations = append(destinations, scheduledUrls...)
+		}
+
+		for _, destination := range destinations {
+			if match, ok := s.reputation.Check(destination); ok {
+				hits = append(hits, reputationHit{
+					id:     lastId,
+					code:   code,
+					reason: fmt.Sprintf("%s listed by %s: %s", match.ThreatType, match.Source, destination),
+				})
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, lastId, ErrExecQuery
+	}
+
+	return hits, lastId, nil
+}
+
+func (s *service) ListFlaggedUrls(ctx context.Context) ([]FlaggedLink, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT u.short_url, u.long_url, COALESCE(o.username, ''), u.flagged_at, u.flag_reason
+		FROM urls u LEFT JOIN users o ON o.id = u.user_id
+		WHERE u.flagged_at IS NOT NULL AND u.deleted_at IS NULL
+		ORDER BY u.flagged_at DESC`,
+	)
+	if err != nil {
+		s.logger.Error("Failed to query flagged urls", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+	defer rows.Close()
+
+	links := []FlaggedLink{}
+	for rows.Next() {
+		var link FlaggedLink
+		if err := rows.Scan(&link.Code, &link.LongUrl, &link.Owner, &link.FlaggedAt, &link.Reason); err != nil {
+			s.logger.Error("An error occurred when scanning row", "error", err.Error())
+			return nil, ErrExecQuery
+		}
+		link.ShortUrl = s.cfg.APP_BASE_URL + "/" + link.Code
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("An error occurred when iterating rows", "error", err.Error())
+		return nil, ErrExecQuery
+	}
+
+	return links, nil
+}
+
+// ClearUrlFlag marks a flagged link as reviewed. It stays disabled until
+// its owner enables it again, and is skipped by later rechecks.
+func (s *service) ClearUrlFlag(ctx context.Context, code string) error {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE urls SET flagged_at = NULL, flag_reason = '', flag_cleared_at = NOW()
+		WHERE short_url = $1 AND flagged_at IS NOT NULL`,
+		code,
+	)
+	if err != nil {
+		s.logger.Error("Failed to clear url flag", "error", err.Error())
+		return ErrExecQuery
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	s.logger.Info("Short URL flag cleared", "code", code)
+
+	return nil
 }
@@ -0,0 +1,149 @@
+package shortener
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/badiwidya/yaurl/internal/config"
+	"github.com/badiwidya/yaurl/internal/pkg/geoip"
+	"github.com/badiwidya/yaurl/internal/pkg/useragent"
+)
+
+// Visitors tells redirect rules and click analytics who is behind a
+// request.
+type Visitors struct {
+	cfg *config.Config
+	geo *geoip.DB
+
+	unfurlMarkers []string
+}
+
+// NewVisitors takes an optional country database, without one countries
+// only come from GEO_COUNTRY_HEADER.
+func NewVisitors(cfg *config.Config, geo *geoip.DB) *Visitors {
+	v := &Visitors{
+		cfg: cfg,
+		geo: geo,
+	}
+
+	for marker := range strings.SplitSeq(cfg.UNFURL_USER_AGENTS, ",") {
+		if marker = strings.ToLower(strings.TrimSpace(marker)); marker != "" {
+			v.unfurlMarkers = append(v.unfurlMarkers, marker)
+		}
+	}
+
+	return v
+}
+
+func (v *Visitors) Describe(r *http.Request) Visitor {
+	agent := useragent.Parse(r.UserAgent())
+
+	return Visitor{
+		OS:       agent.OS,
+		Device:   agent.Device,
+		Country:  v.Country(r),
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+		Referrer: referrerHost(r.Referer()),
+		Key:      clientIP(r, v.cfg.TRUST_PROXY_HEADERS) + "\x00" + r.UserAgent(),
+	}
+}
+
+// Unfurler reports whether r comes from a crawler building a link preview
+// for a chat app or social network, including the extra user agents of
+// UNFURL_USER_AGENTS.
+func (v *Visitors) Unfurler(r *http.Request) bool {
+	if !v.cfg.UNFURL_ENABLED {
+		return false
+	}
+
+	ua := r.UserAgent()
+	if useragent.IsUnfurler(ua) {
+		return true
+	}
+
+	lower := strings.ToLower(ua)
+	for _, marker := range v.unfurlMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Country returns the ISO 3166 code of the visitor's country. A code set
+// by an upstream proxy or CDN, e.g. CF-IPCountry, when GEO_COUNTRY_HEADER
+// names one, wins over a lookup of the client address in the database.
+func (v *Visitors) Country(r *http.Request) string {
+	if v.cfg.GEO_COUNTRY_HEADER != "" {
+		code := strings.ToUpper(strings.TrimSpace(r.Header.Get(v.cfg.GEO_COUNTRY_HEADER)))
+		if isCountryCode(code) {
+			return code
+		}
+	}
+
+	if v.geo == nil {
+		return ""
+	}
+
+	addr, err := netip.ParseAddr(clientIP(r, v.cfg.TRUST_PROXY_HEADERS))
+	if err != nil {
+		return ""
+	}
+
+	code := strings.ToUpper(v.geo.Country(addr))
+	if !isCountryCode(code) {
+		return ""
+	}
+
+	return code
+}
+
+func isCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
+
+// preferredLanguage returns the lower-cased tag with the highest quality
+// in an Accept-Language header, the first one listed on a tie.
+func preferredLanguage(header string) string {
+	best, bestQuality := "", 0.0
+
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for param := range strings.SplitSeq(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+
+	return best
+}
+
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return normalizeDomain(parsed.Hostname())
+}
@@ -0,0 +1,32 @@
+package shortener
+
+import "testing"
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"en", "en"},
+		{"en-US,en;q=0.9,id;q=0.8", "en-us"},
+		{"id;q=0.8, fr-CA;q=0.95, de;q=0.9", "fr-ca"},
+		{"da, en-gb;q=0.8, en;q=0.7", "da"},
+		{"de;q=0.5, fr;q=0.5", "de"},
+		{"de;q=0.5, fr", "fr"},
+		{"*;q=1, es;q=0.1", "es"},
+		{"*", ""},
+		{"en;q=0", ""},
+		{"en;q=0, fr;q=0.001", "fr"},
+		{"nl;q=abc, fr;q=0.9", "nl"},
+		{"en ; q=0.4 , ja ;q=0.6", "ja"},
+		{"es;level=1;q=0.3, pt;q=0.2", "es"},
+		{" , ,en-AU", "en-au"},
+	}
+
+	for _, tt := range tests {
+		if got := preferredLanguage(tt.header); got != tt.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+-- MySQL dump 10.13  Distrib 8.0.36
+/*!40101 SET NAMES utf8mb4 */;
+
+DROP TABLE IF EXISTS `yourls_options`;
+CREATE TABLE `yourls_options` (
+  `option_id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `option_name` varchar(64) NOT NULL DEFAULT '',
+  `option_value` longtext NOT NULL,
+  PRIMARY KEY (`option_id`)
+);
+INSERT INTO `yourls_options` VALUES (1,'version','1.9.2'),(2,'active_plugins','a:1:{i:0;s:6:\"x;y\";}');
+
+DROP TABLE IF EXISTS `yourls_url`;
+CREATE TABLE `yourls_url` (
+  `keyword` varchar(100) NOT NULL,
+  `url` text NOT NULL,
+  `title` text,
+  `timestamp` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `ip` varchar(41) NOT NULL,
+  `clicks` int unsigned NOT NULL,
+  PRIMARY KEY (`keyword`)
+);
+LOCK TABLES `yourls_url` WRITE;
+INSERT INTO `yourls_url` VALUES ('gh','https://github.com/','GitHub; where the code lives','2022-01-02 03:04:05','127.0.0.1',12),('quote','https://example.com/?q=it''s','It\'s \"quoted\"\nover lines','2022-02-03 04:05:06','::1',0),
+('nulls','https://example.com/nulls',NULL,'2022-03-04 05:06:07','10.0.0.1',3);
+UNLOCK TABLES;
+
+insert into yourls.yourls_url (`url`, `keyword`, `timestamp`) values
+  ('https://example.com/listed', 'listed', '2022-04-05 06:07:08'),
+  ('https://example.com/nodate', 'nodate', NULL);
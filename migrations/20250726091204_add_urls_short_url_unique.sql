@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE urls
+SET short_url = urls.short_url || '-' || urls.id
+FROM urls AS first
+WHERE first.short_url = urls.short_url
+AND first.id < urls.id;
+
+ALTER TABLE urls
+ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+
+CREATE SEQUENCE urls_code_seq;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP SEQUENCE IF EXISTS urls_code_seq;
+
+ALTER TABLE urls
+DROP CONSTRAINT urls_short_url_key;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE clicks (
+	id BIGSERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+	short_code VARCHAR(255) NOT NULL,
+	clicked_at TIMESTAMPTZ NOT NULL,
+	referrer TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip_hash VARCHAR(64) NOT NULL DEFAULT '',
+	accept_language TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX clicks_url_id_clicked_at_idx ON clicks (url_id, clicked_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS clicks;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX urls_user_id_created_at_idx ON urls (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS urls_user_id_created_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN created_at;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE url_history (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+	changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	previous JSONB NOT NULL
+);
+
+CREATE INDEX url_history_url_id_idx ON url_history (url_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS url_history;
+-- +goose StatementEnd
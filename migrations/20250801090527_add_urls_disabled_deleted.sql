@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN disabled_at TIMESTAMPTZ,
+ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX urls_deleted_at_idx ON urls (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS urls_deleted_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN disabled_at,
+DROP COLUMN deleted_at;
+-- +goose StatementEnd
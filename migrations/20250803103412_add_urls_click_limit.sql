@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN max_clicks INTEGER CHECK (max_clicks > 0),
+ADD COLUMN remaining_clicks INTEGER CHECK (remaining_clicks >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE urls
+DROP COLUMN max_clicks,
+DROP COLUMN remaining_clicks;
+-- +goose StatementEnd
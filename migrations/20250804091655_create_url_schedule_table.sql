@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN active_from TIMESTAMPTZ;
+
+CREATE TABLE url_schedule (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+	starts_at TIMESTAMPTZ NOT NULL,
+	long_url TEXT NOT NULL,
+	UNIQUE (url_id, starts_at)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS url_schedule;
+
+ALTER TABLE urls
+DROP COLUMN active_from;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE urls
+ADD COLUMN force_preview BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE preview_domains (
+	domain TEXT PRIMARY KEY,
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS preview_domains;
+
+ALTER TABLE urls
+DROP COLUMN force_preview;
+
+ALTER TABLE users
+DROP COLUMN is_admin;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN forward_query TEXT NOT NULL DEFAULT '',
+ADD COLUMN forward_path BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE urls
+DROP COLUMN forward_query,
+DROP COLUMN forward_path;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN variants JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE clicks
+ADD COLUMN variant TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE clicks
+DROP COLUMN variant;
+
+ALTER TABLE urls
+DROP COLUMN variants;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN redirect_status SMALLINT CHECK (redirect_status IN (301, 302, 307, 308));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE urls
+DROP COLUMN redirect_status;
+-- +goose StatementEnd
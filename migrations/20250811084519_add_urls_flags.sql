@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN flagged_at TIMESTAMPTZ,
+ADD COLUMN flag_reason TEXT NOT NULL DEFAULT '',
+ADD COLUMN flag_cleared_at TIMESTAMPTZ;
+
+CREATE INDEX urls_flagged_at_idx ON urls (flagged_at) WHERE flagged_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS urls_flagged_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN flag_cleared_at,
+DROP COLUMN flag_reason,
+DROP COLUMN flagged_at;
+-- +goose StatementEnd
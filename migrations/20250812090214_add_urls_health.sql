@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN fallback_url TEXT,
+ADD COLUMN health_status TEXT NOT NULL DEFAULT 'unknown' CHECK (health_status IN ('unknown', 'healthy', 'failing')),
+ADD COLUMN health_url TEXT,
+ADD COLUMN health_checked_at TIMESTAMPTZ,
+ADD COLUMN health_failures INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX urls_health_checked_at_idx ON urls (health_checked_at NULLS FIRST);
+
+CREATE TABLE url_health_checks (
+	id BIGSERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+	checked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	url TEXT NOT NULL,
+	healthy BOOLEAN NOT NULL,
+	status_code INTEGER,
+	error TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL
+);
+
+CREATE INDEX url_health_checks_url_id_checked_at_idx ON url_health_checks (url_id, checked_at);
+
+CREATE TABLE url_events (
+	id BIGSERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX url_events_url_id_idx ON url_events (url_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS url_events;
+DROP TABLE IF EXISTS url_health_checks;
+DROP INDEX IF EXISTS urls_health_checked_at_idx;
+
+ALTER TABLE urls
+DROP COLUMN health_failures,
+DROP COLUMN health_checked_at,
+DROP COLUMN health_url,
+DROP COLUMN health_status,
+DROP COLUMN fallback_url;
+-- +goose StatementEnd
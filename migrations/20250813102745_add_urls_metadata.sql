@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE urls
+ADD COLUMN metadata JSONB,
+ADD COLUMN metadata_fetched_at TIMESTAMPTZ,
+ADD COLUMN metadata_error TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE urls
+DROP COLUMN metadata_error,
+DROP COLUMN metadata_fetched_at,
+DROP COLUMN metadata;
+-- +goose StatementEnd